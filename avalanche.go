@@ -0,0 +1,55 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+)
+
+// avalanche encrypts the image m and a copy of it with its first bit
+// flipped, and returns a map of the output blocks that differ between
+// the two ciphertexts. The changed blocks are white and the unchanged
+// blocks black.
+func avalanche(m image.Image, filter filterSpec) (*image.NRGBA, int, error) {
+	flipped, err := processImage(m, filterSpec{f: FilterCopy})
+	if err != nil {
+		return nil, 0, err
+	}
+	flipped.Pix[0] ^= 0x01
+
+	a, err := processImage(m, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	b, err := processImage(flipped, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	result := image.NewNRGBA(a.Rect)
+	var changed int
+
+	err = forEachBlock(a.Rect.Dx(), a.Rect.Dy(), filter.size()/4,
+		func(pos BlockPos) error {
+			c := color.NRGBA{A: 0xff}
+			if !bytes.Equal(blockPixels(a, pos), blockPixels(b, pos)) {
+				c = color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+				changed++
+			}
+			for i := 0; i < pos.N; i++ {
+				x, y := pos.Pixel(i)
+				result.SetNRGBA(x, y, c)
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, 0, err
+	}
+	return result, changed, nil
+}
@@ -0,0 +1,74 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"crypto/rand"
+	"image"
+	"math"
+)
+
+// randomImage creates an image of the given size with random pixels
+// from crypto/rand. It is the ideal output of a cipher mode and
+// serves as the baseline for comparing filter outputs.
+func randomImage(width, height int) (*image.NRGBA, error) {
+	m := image.NewNRGBA(image.Rect(0, 0, width, height))
+	_, err := rand.Read(m.Pix)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// histogram returns the byte value histogram of the image pixels.
+func histogram(m *image.NRGBA) *[256]int {
+	var result [256]int
+
+	for y := m.Rect.Min.Y; y < m.Rect.Max.Y; y++ {
+		ofs := m.PixOffset(m.Rect.Min.X, y)
+		for _, b := range m.Pix[ofs : ofs+m.Rect.Dx()*4] {
+			result[b]++
+		}
+	}
+	return &result
+}
+
+// entropy computes the Shannon entropy of the histogram in bits per
+// byte.
+func entropy(h *[256]int) float64 {
+	var total int
+	for _, count := range h {
+		total += count
+	}
+
+	var result float64
+	for _, count := range h {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / float64(total)
+		result -= p * math.Log2(p)
+	}
+	return result
+}
+
+// chiSquare computes the chi-square distance between two histograms.
+// The distance between two random images is roughly the number of
+// histogram bins and it grows as the images get more structured.
+func chiSquare(a, b *[256]int) float64 {
+	var result float64
+
+	for i := 0; i < len(a); i++ {
+		sum := a[i] + b[i]
+		if sum == 0 {
+			continue
+		}
+		diff := float64(a[i] - b[i])
+		result += diff * diff / float64(sum)
+	}
+	return result
+}
@@ -0,0 +1,72 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"crypto/aes"
+	"image"
+	"image/color"
+)
+
+// bitplaneECB encrypts each of the 8 bit-planes of the luminance of
+// the image m separately with AES-ECB and returns the recombined
+// grayscale image. The bits of a plane are packed into bytes in the
+// raster order, most significant bit first, and the last block of a
+// plane is padded with zero bits. The structure that survives in the
+// output shows which bit-planes carry the image content: the high
+// planes have long runs of identical blocks while the low planes are
+// mostly noise.
+func bitplaneECB(m image.Image) *image.NRGBA {
+	bounds := m.Bounds()
+	width := bounds.Dx()
+	height := bounds.Dy()
+	pixels := width * height
+
+	luma := make([]byte, pixels)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.GrayModel.Convert(m.At(bounds.Min.X+x,
+				bounds.Min.Y+y)).(color.Gray)
+			luma[y*width+x] = c.Y
+		}
+	}
+
+	n := (pixels + 7) / 8
+	n = (n + aes.BlockSize - 1) / aes.BlockSize * aes.BlockSize
+	plane := make([]byte, n)
+	result := make([]byte, pixels)
+
+	for bit := 0; bit < 8; bit++ {
+		for i := range plane {
+			plane[i] = 0
+		}
+		for i, v := range luma {
+			if v&(1<<bit) != 0 {
+				plane[i/8] |= 0x80 >> (i % 8)
+			}
+		}
+		for i := 0; i < len(plane); i += aes.BlockSize {
+			cipherAES256.Encrypt(plane[i:], plane[i:])
+		}
+		for i := range result {
+			if plane[i/8]&(0x80>>(i%8)) != 0 {
+				result[i] |= 1 << bit
+			}
+		}
+	}
+
+	output := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i, v := range result {
+		output.SetNRGBA(i%width, i/width, color.NRGBA{
+			R: v,
+			G: v,
+			B: v,
+			A: 0xff,
+		})
+	}
+	return output
+}
@@ -0,0 +1,160 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"image"
+)
+
+// NewAESCBC creates an AES-CBC filter. The image is encrypted as one
+// CBC chain which starts from an all-zero IV.
+func NewAESCBC() Filter {
+	return newAESCBC(nil)
+}
+
+// newAESCBC creates an AES-CBC filter. The optional debug function is
+// called with the sequence number and the cipher input of each block,
+// that is, the plaintext XORed with the previous ciphertext block.
+func newAESCBC(debug func(seq int, input []byte)) Filter {
+	var prev [16]byte
+
+	return func(block []byte, seq int) error {
+		for i := 0; i < len(block); i++ {
+			block[i] ^= prev[i]
+		}
+		if debug != nil {
+			debug(seq, block)
+		}
+		cipherAES256.Encrypt(block, block)
+		copy(prev[:], block)
+		return nil
+	}
+}
+
+// cbcChainImage encrypts the image m with AES-CBC and returns an image
+// of the cipher inputs of the blocks. The blocks that are not
+// encrypted keep their pixels.
+func cbcChainImage(m image.Image) (*image.NRGBA, error) {
+	inputs := make(map[int][]byte)
+
+	filter := newAESCBC(func(seq int, input []byte) {
+		inputs[seq] = append([]byte(nil), input...)
+	})
+	result, err := processImage(m, filterSpec{f: filter})
+	if err != nil {
+		return nil, err
+	}
+
+	var seq int
+	err = forEachBlock(result.Rect.Dx(), result.Rect.Dy(), aes.BlockSize/4,
+		func(pos BlockPos) error {
+			// The blocks after -max-blocks and the blocks skipped with
+			// -skip-transparent are not encrypted.
+			if input, ok := inputs[seq]; ok {
+				writeBlock(result, input[:pos.N*4], seq, pos)
+			}
+			seq++
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// NewAESCBCDecrypt creates the AES-CBC decryption filter.
+func NewAESCBCDecrypt() Filter {
+	var prev, ct [16]byte
+
+	return func(block []byte, seq int) error {
+		copy(ct[:], block)
+		cipherAES256.Decrypt(block, block)
+		for i := 0; i < len(block); i++ {
+			block[i] ^= prev[i]
+		}
+		prev = ct
+		return nil
+	}
+}
+
+// hmacKey is the HMAC-SHA256 key of the AES-CBC-HMAC filter. It is
+// derived from the AES key in setupKey.
+var hmacKey []byte
+
+var errMAC = errors.New("aes-cbc-hmac: message authentication failed")
+
+// AESCBCHMAC encrypts each block as an encrypt-then-MAC message:
+// the block is CBC-encrypted with an IV derived from seq and the
+// ciphertext is authenticated with HMAC-SHA256. Like with AES-GCM, the
+// output image holds only the ciphertext and the tag is dropped.
+func AESCBCHMAC(block []byte, seq int) ([]byte, error) {
+	return sealCBCHMAC(seq, block), nil
+}
+
+// cbcIV derives the CBC IV for the message seq by encrypting it with
+// the AES key.
+func cbcIV(seq int) [16]byte {
+	var iv [16]byte
+
+	binary.BigEndian.PutUint64(iv[8:16], uint64(seq))
+	cipherAES256.Encrypt(iv[:], iv[:])
+	return iv
+}
+
+// sealCBCHMAC encrypts the 16-byte plaintext and returns the
+// ciphertext followed by its HMAC-SHA256 tag.
+func sealCBCHMAC(seq int, plaintext []byte) []byte {
+	iv := cbcIV(seq)
+
+	ct := make([]byte, 16, 16+sha256.Size)
+	for i := 0; i < len(ct); i++ {
+		ct[i] = plaintext[i] ^ iv[i]
+	}
+	cipherAES256.Encrypt(ct, ct)
+
+	return append(ct, cbcHMACTag(iv[:], ct)...)
+}
+
+// openCBCHMAC verifies the tag of the sealed message and returns the
+// decrypted plaintext.
+func openCBCHMAC(seq int, sealed []byte) ([]byte, error) {
+	if len(sealed) != 16+sha256.Size {
+		return nil, errMAC
+	}
+	iv := cbcIV(seq)
+	ct := sealed[:16]
+
+	tag := cbcHMACTag(iv[:], ct)
+	if !equalBlocks(tag, sealed[16:]) {
+		return nil, errMAC
+	}
+
+	plaintext := make([]byte, 16)
+	cipherAES256.Decrypt(plaintext, ct)
+	for i := 0; i < len(plaintext); i++ {
+		plaintext[i] ^= iv[i]
+	}
+	return plaintext, nil
+}
+
+func cbcHMACTag(iv, ct []byte) []byte {
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write(iv)
+	mac.Write(ct)
+	return mac.Sum(nil)
+}
+
+func deriveHMACKey(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("crypto-modes AES-CBC-HMAC"))
+	return mac.Sum(nil)
+}
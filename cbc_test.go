@@ -0,0 +1,85 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCBCChainSkipTransparent(t *testing.T) {
+	skipTransparent = true
+	defer func() {
+		skipTransparent = false
+	}()
+
+	m := transparentTestImage(40, 5)
+	chain, err := cbcChainImage(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cbc, err := lookupFilter("AES-CBC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, err := processImage(m, cbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forEachBlock(m.Rect.Dx(), m.Rect.Dy(), 4, func(pos BlockPos) error {
+		if transparent(blockPixels(m, pos)) {
+			if !bytes.Equal(blockPixels(chain, pos), blockPixels(m, pos)) {
+				t.Errorf("skipped block at %v: chain image changed", pos)
+			}
+			return nil
+		}
+		input := blockPixels(chain, pos)
+		cipherAES256.Encrypt(input, input)
+		if !bytes.Equal(input, blockPixels(ct, pos)) {
+			t.Errorf("block at %v: chain input does not encrypt to the "+
+				"ciphertext", pos)
+		}
+		return nil
+	})
+}
+
+func TestCBCHMAC(t *testing.T) {
+	plaintext := testBlock(16)
+
+	for seq := 0; seq < 4; seq++ {
+		sealed, err := AESCBCHMAC(plaintext, seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opened, err := openCBCHMAC(seq, sealed)
+		if err != nil {
+			t.Fatalf("seq %d: %v", seq, err)
+		}
+		if !bytes.Equal(opened, plaintext) {
+			t.Errorf("seq %d: opened %x, expected %x", seq, opened, plaintext)
+		}
+
+		// Modified ciphertext, tag, and seq.
+		for _, i := range []int{0, 15, 16, len(sealed) - 1} {
+			tampered := append([]byte(nil), sealed...)
+			tampered[i] ^= 0x01
+			if _, err := openCBCHMAC(seq, tampered); err != errMAC {
+				t.Errorf("seq %d: modified byte %d: got %v, expected %v",
+					seq, i, err, errMAC)
+			}
+		}
+		if _, err := openCBCHMAC(seq+1, sealed); err != errMAC {
+			t.Errorf("seq %d: opened with wrong seq: got %v, expected %v",
+				seq, err, errMAC)
+		}
+		if _, err := openCBCHMAC(seq, sealed[:16]); err != errMAC {
+			t.Errorf("seq %d: opened without tag: got %v, expected %v",
+				seq, err, errMAC)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"errors"
+	"image"
+	"image/color"
+)
+
+var errPadding = errors.New("invalid PKCS #7 padding")
+
+// pkcs7Pad pads the data to a multiple of the block size with PKCS
+// #7 padding. The padding is always 1 to blockSize bytes.
+func pkcs7Pad(data []byte, blockSize int) []byte {
+	n := blockSize - len(data)%blockSize
+	return append(data, bytes.Repeat([]byte{byte(n)}, n)...)
+}
+
+// pkcs7Unpad removes the PKCS #7 padding from the data.
+func pkcs7Unpad(data []byte, blockSize int) ([]byte, error) {
+	if len(data) == 0 || len(data)%blockSize != 0 {
+		return nil, errPadding
+	}
+	n := int(data[len(data)-1])
+	if n == 0 || n > blockSize {
+		return nil, errPadding
+	}
+	for _, b := range data[len(data)-n:] {
+		if int(b) != n {
+			return nil, errPadding
+		}
+	}
+	return data[:len(data)-n], nil
+}
+
+// cbcPadded encrypts the pixel bytes of the image m as one message
+// with PKCS #7 padding and AES-CBC, starting from an all-zero IV. The
+// ciphertext is one block longer than the pixels if the image size is
+// a multiple of the block size, otherwise it is padded to the next
+// block. The ct image has extra rows for holding the ciphertext bytes
+// past the size of the image. The structure image shows what an
+// attacker sees of the ciphertext: the block boundaries in
+// alternating shades, the last block in orange, and its padding bytes
+// in red.
+func cbcPadded(m image.Image) (ct, structure *image.NRGBA, err error) {
+	plain, err := processImage(m, filterSpec{f: FilterCopy})
+	if err != nil {
+		return nil, nil, err
+	}
+	width := plain.Rect.Dx()
+	height := plain.Rect.Dy()
+	n := len(plain.Pix)
+
+	data := pkcs7Pad(append([]byte(nil), plain.Pix...), aes.BlockSize)
+	var prev [aes.BlockSize]byte
+	for i := 0; i < len(data); i += aes.BlockSize {
+		block := data[i : i+aes.BlockSize]
+		for j := 0; j < aes.BlockSize; j++ {
+			block[j] ^= prev[j]
+		}
+		cipherAES256.Encrypt(block, block)
+		copy(prev[:], block)
+	}
+
+	// Verify the round trip through decryption and unpadding.
+	decrypted, err := cbcUnpad(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(decrypted, plain.Pix) {
+		return nil, nil, errors.New("aes-cbc: round trip failed")
+	}
+
+	rowBytes := width * 4
+	rows := height + (len(data)-n+rowBytes-1)/rowBytes
+	ct = image.NewNRGBA(image.Rect(0, 0, width, rows))
+	copy(ct.Pix, data)
+
+	last := len(data) - aes.BlockSize
+	structure = image.NewNRGBA(ct.Rect)
+	for i := 0; i < len(data)/4; i++ {
+		ofs := i * 4
+		var c color.NRGBA
+		switch {
+		case ofs >= n:
+			c = color.NRGBA{R: 0xe0, G: 0x20, B: 0x20, A: 0xff}
+		case ofs >= last:
+			c = color.NRGBA{R: 0xf0, G: 0xa0, B: 0x20, A: 0xff}
+		case (ofs/aes.BlockSize)%2 == 0:
+			c = color.NRGBA{R: 0x60, G: 0x60, B: 0x60, A: 0xff}
+		default:
+			c = color.NRGBA{R: 0xa0, G: 0xa0, B: 0xa0, A: 0xff}
+		}
+		structure.SetNRGBA(i%width, i/width, c)
+	}
+	return ct, structure, nil
+}
+
+// cbcUnpad decrypts the AES-CBC ciphertext and removes its PKCS #7
+// padding.
+func cbcUnpad(ct []byte) ([]byte, error) {
+	if len(ct)%aes.BlockSize != 0 {
+		return nil, errPadding
+	}
+	result := make([]byte, len(ct))
+	var prev [aes.BlockSize]byte
+	for i := 0; i < len(ct); i += aes.BlockSize {
+		cipherAES256.Decrypt(result[i:i+aes.BlockSize], ct[i:i+aes.BlockSize])
+		for j := 0; j < aes.BlockSize; j++ {
+			result[i+j] ^= prev[j]
+		}
+		copy(prev[:], ct[i:i+aes.BlockSize])
+	}
+	return pkcs7Unpad(result, aes.BlockSize)
+}
@@ -0,0 +1,76 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestPKCS7(t *testing.T) {
+	for n := 0; n <= 33; n++ {
+		data := testBlock(n)
+		padded := pkcs7Pad(append([]byte(nil), data...), 16)
+		if len(padded)%16 != 0 || len(padded) <= n || len(padded) > n+16 {
+			t.Errorf("%d bytes: padded length %d", n, len(padded))
+		}
+		unpadded, err := pkcs7Unpad(padded, 16)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if !bytes.Equal(unpadded, data) {
+			t.Errorf("%d bytes: unpadded %x, expected %x", n, unpadded, data)
+		}
+	}
+
+	for _, data := range [][]byte{
+		nil,
+		testBlock(15),
+		append(testBlock(15), 0x00),
+		append(testBlock(15), 0x11),
+		append(testBlock(14), 0x01, 0x02),
+		append(testBlock(13), 0x02, 0x03, 0x03),
+	} {
+		if _, err := pkcs7Unpad(data, 16); err != errPadding {
+			t.Errorf("%x: got %v, expected %v", data, err, errPadding)
+		}
+	}
+}
+
+func TestCBCPadded(t *testing.T) {
+	for _, size := range []image.Point{
+		{1, 1}, {2, 2}, {3, 1}, {1, 7}, {4, 4}, {5, 3}, {37, 5},
+	} {
+		m := image.NewNRGBA(image.Rect(0, 0, size.X, size.Y))
+		for i := range m.Pix {
+			m.Pix[i] = byte(i * 11)
+		}
+		ct, structure, err := cbcPadded(m)
+		if err != nil {
+			t.Fatalf("%v: %v", size, err)
+		}
+		if structure.Rect != ct.Rect {
+			t.Errorf("%v: structure bounds %v, expected %v", size,
+				structure.Rect, ct.Rect)
+		}
+
+		// The ct image holds the whole ciphertext.
+		length := (len(m.Pix)/16 + 1) * 16
+		if len(ct.Pix) < length {
+			t.Fatalf("%v: ct image has %d bytes, ciphertext %d bytes",
+				size, len(ct.Pix), length)
+		}
+		decrypted, err := cbcUnpad(ct.Pix[:length])
+		if err != nil {
+			t.Fatalf("%v: %v", size, err)
+		}
+		if !bytes.Equal(decrypted, m.Pix) {
+			t.Errorf("%v: decrypted ct image differs from the input", size)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+// checkerCell is the size of the Checkerboard filter squares in
+// pixels. It is a multiple of the 4-pixel block width.
+const checkerCell = 64
+
+// checkerWhite tests if the block at pos is on a white square.
+func checkerWhite(pos BlockPos) bool {
+	return (pos.X/checkerCell+pos.Y/checkerCell)%2 == 0
+}
+
+// NewCheckerboard creates a filter which encrypts the blocks on the
+// white squares of a checkerboard with AES-ECB and the blocks on the
+// black squares with AES-CTR. The ECB squares leak the image
+// structure while the CTR squares look like noise.
+func NewCheckerboard() PosFilter {
+	ctr := NewAESCTR()
+
+	return func(block []byte, seq int, pos BlockPos) error {
+		if checkerWhite(pos) {
+			return AESECB(block, seq)
+		}
+		return ctr(block, seq)
+	}
+}
+
+// NewCheckerboardDecrypt creates the Checkerboard decryption filter.
+func NewCheckerboardDecrypt() PosFilter {
+	ctr := NewAESCTR()
+
+	return func(block []byte, seq int, pos BlockPos) error {
+		if checkerWhite(pos) {
+			return AESECBDecrypt(block, seq)
+		}
+		return ctr(block, seq)
+	}
+}
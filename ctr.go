@@ -0,0 +1,83 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"fmt"
+)
+
+var counterLittleEndian bool
+
+func parseEndian(val string) (bool, error) {
+	switch val {
+	case "big":
+		return false, nil
+	case "little":
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid counter endianness: %s", val)
+	}
+}
+
+// NewAESCTR creates an AES-CTR filter. The counter block starts from
+// zero and it is incremented for each block as a big-endian or
+// little-endian integer, selected with the -counter-endian flag. The
+// same filter both encrypts and decrypts.
+func NewAESCTR() Filter {
+	return newAESCTR(0)
+}
+
+// ctrWrap is the number of counter values of the AES-CTR-Wrap filter,
+// set with the -ctr-wrap flag.
+var ctrWrap = 128
+
+// NewAESCTRWrap creates an AES-CTR filter whose counter wraps back to
+// zero after -ctr-wrap blocks. The keystream repeats within the image
+// and the plaintext structure reappears in the output.
+func NewAESCTRWrap() Filter {
+	return newAESCTR(ctrWrap)
+}
+
+// newAESCTR creates an AES-CTR filter. If wrap is positive, the
+// counter is reset to zero after every wrap blocks.
+func newAESCTR(wrap int) Filter {
+	var counter [16]byte
+	var count int
+
+	return func(block []byte, seq int) error {
+		var keystream [16]byte
+
+		cipherAES256.Encrypt(keystream[:], counter[:])
+		for i := 0; i < len(block); i++ {
+			block[i] ^= keystream[i]
+		}
+		incrementCounter(&counter, counterLittleEndian)
+		count++
+		if wrap > 0 && count%wrap == 0 {
+			counter = [16]byte{}
+		}
+		return nil
+	}
+}
+
+func incrementCounter(counter *[16]byte, littleEndian bool) {
+	if littleEndian {
+		for i := 0; i < len(counter); i++ {
+			counter[i]++
+			if counter[i] != 0 {
+				return
+			}
+		}
+	} else {
+		for i := len(counter) - 1; i >= 0; i-- {
+			counter[i]++
+			if counter[i] != 0 {
+				return
+			}
+		}
+	}
+}
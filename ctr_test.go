@@ -0,0 +1,78 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestIncrementCounter(t *testing.T) {
+	var counter [16]byte
+
+	// Big-endian carry from the last byte to the previous one.
+	counter[15] = 0xff
+	incrementCounter(&counter, false)
+	if counter[14] != 0x01 || counter[15] != 0x00 {
+		t.Errorf("big-endian carry: got %x", counter)
+	}
+
+	// Little-endian carry from the first byte to the next one.
+	counter = [16]byte{}
+	counter[0] = 0xff
+	incrementCounter(&counter, true)
+	if counter[0] != 0x00 || counter[1] != 0x01 {
+		t.Errorf("little-endian carry: got %x", counter)
+	}
+
+	// Carry over all bytes wraps to zero.
+	for _, littleEndian := range []bool{false, true} {
+		for i := range counter {
+			counter[i] = 0xff
+		}
+		incrementCounter(&counter, littleEndian)
+		if counter != [16]byte{} {
+			t.Errorf("little-endian %v: wrap: got %x", littleEndian, counter)
+		}
+	}
+}
+
+// ctrApply applies a new AES-CTR filter to the 16-byte blocks of data.
+func ctrApply(t *testing.T, data []byte) []byte {
+	result := append([]byte(nil), data...)
+	filter := NewAESCTR()
+	for seq := 0; seq < len(result)/16; seq++ {
+		if err := filter(result[seq*16:(seq+1)*16], seq); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return result
+}
+
+func TestAESCTRRoundTrip(t *testing.T) {
+	defer func() {
+		counterLittleEndian = false
+	}()
+
+	// Encrypt past the first counter byte boundary.
+	plaintext := testBlock(300 * 16)
+
+	var outputs [][]byte
+	for _, littleEndian := range []bool{false, true} {
+		counterLittleEndian = littleEndian
+
+		ct := ctrApply(t, plaintext)
+		if !bytes.Equal(ctrApply(t, ct), plaintext) {
+			t.Errorf("little-endian %v: decryption failed", littleEndian)
+		}
+		outputs = append(outputs, ct)
+	}
+	if bytes.Equal(outputs[0], outputs[1]) {
+		t.Errorf("big-endian and little-endian counters give the same " +
+			"ciphertext")
+	}
+}
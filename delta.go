@@ -0,0 +1,45 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"fmt"
+	"image"
+)
+
+// prevFrame is the previous input image of the -delta mode.
+var prevFrame *image.NRGBA
+
+// deltaFrame returns the difference of the input image m and the
+// previous input image. The color components are subtracted modulo
+// 256 and the alpha is kept from m. The first frame is returned
+// unchanged.
+func deltaFrame(m image.Image) (*image.NRGBA, error) {
+	frame, err := processImage(m, filterSpec{f: FilterCopy})
+	if err != nil {
+		return nil, err
+	}
+	prev := prevFrame
+	prevFrame = frame
+	if prev == nil {
+		return frame, nil
+	}
+	if !prev.Rect.Eq(frame.Rect) {
+		return nil, fmt.Errorf("frame size %d×%d differs from the "+
+			"previous frame size %d×%d", frame.Rect.Dx(), frame.Rect.Dy(),
+			prev.Rect.Dx(), prev.Rect.Dy())
+	}
+
+	result := image.NewNRGBA(frame.Rect)
+	for i := 0; i < len(frame.Pix); i += 4 {
+		result.Pix[i+0] = frame.Pix[i+0] - prev.Pix[i+0]
+		result.Pix[i+1] = frame.Pix[i+1] - prev.Pix[i+1]
+		result.Pix[i+2] = frame.Pix[i+2] - prev.Pix[i+2]
+		result.Pix[i+3] = frame.Pix[i+3]
+	}
+	return result, nil
+}
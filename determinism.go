@@ -0,0 +1,76 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"os"
+)
+
+// expectedDeterminism lists the filters whose determinism is
+// asserted: true for filters that must produce identical outputs and
+// false for filters that must not.
+var expectedDeterminism = map[string]bool{
+	"AES-GCM-SIV":         true,
+	"AES-GCM-RandomNonce": false,
+}
+
+// determinismCommand implements the determinism subcommand which
+// encrypts the image twice with each filter and reports whether the
+// outputs are identical. The deterministic filters, such as AES-ECB
+// and AES-GCM-SIV, produce identical outputs while the filters with
+// random nonces or IVs, such as AES-GCM-RandomNonce, don't.
+func determinismCommand(args []string) error {
+	fs := flag.NewFlagSet("determinism", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("usage: determinism image")
+	}
+	m, err := loadNRGBA(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return determinism(m, os.Stdout)
+}
+
+// determinism encrypts the image m twice with each filter and writes
+// the results to w. It returns an error if the determinism of a
+// filter differs from its expectedDeterminism.
+func determinism(m image.Image, w io.Writer) error {
+	var failed []string
+	for _, filter := range filters {
+		first, err := processImage(m, filter)
+		if err != nil {
+			return err
+		}
+		second, err := processImage(m, filter)
+		if err != nil {
+			return err
+		}
+		deterministic := bytes.Equal(first.Pix, second.Pix)
+		result := "randomized"
+		if deterministic {
+			result = "deterministic"
+		}
+		expected, ok := expectedDeterminism[filter.name]
+		if ok && expected != deterministic {
+			result += " (FAILED)"
+			failed = append(failed, filter.name)
+		}
+		fmt.Fprintf(w, "%-22s %s\n", filter.name, result)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("unexpected determinism: %v", failed)
+	}
+	return nil
+}
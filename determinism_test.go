@@ -0,0 +1,52 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// zeroReader returns zeros as its random data.
+type zeroReader struct{}
+
+func (r zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestDeterminism(t *testing.T) {
+	m := genImage(penguin, 32, 16, 8)
+
+	var out strings.Builder
+	if err := determinism(m, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"AES-GCM-SIV            deterministic",
+		"AES-GCM-RandomNonce    randomized",
+		"AES-ECB                deterministic",
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("missing result %q", line)
+		}
+	}
+
+	// With constant random data, the random nonces repeat.
+	defer func(r io.Reader) {
+		RandReader = r
+	}(RandReader)
+	RandReader = zeroReader{}
+
+	err := determinism(m, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "AES-GCM-RandomNonce") {
+		t.Errorf("got %v, expected AES-GCM-RandomNonce to fail", err)
+	}
+}
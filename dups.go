@@ -0,0 +1,103 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"image"
+	"image/color"
+)
+
+var dupColor = color.NRGBA{R: 0xff, G: 0x00, B: 0xff, A: 0xff}
+
+// dupGroupColors paints each group of duplicate blocks with its own
+// color, set with the -dup-colors flag.
+var dupGroupColors bool
+
+// groupColor returns the color of the duplicate block group. The
+// color is derived from the hash of the block so it is stable between
+// runs and filters.
+func groupColor(block string) color.NRGBA {
+	sum := sha256.Sum256([]byte(block))
+
+	h := float64(binary.BigEndian.Uint16(sum[0:2])) / 65536 * 6
+	s := 0.6 + 0.4*float64(sum[2])/255
+	v := 0.7 + 0.3*float64(sum[3])/255
+
+	r, g, b := hue(h)
+	return color.NRGBA{
+		R: uint8((1 - s + s*r) * v * 0xff),
+		G: uint8((1 - s + s*g) * v * 0xff),
+		B: uint8((1 - s + s*b) * v * 0xff),
+		A: 0xff,
+	}
+}
+
+// blockPixels returns the pixel bytes of the block at pos.
+func blockPixels(m *image.NRGBA, pos BlockPos) []byte {
+	result := make([]byte, 0, pos.N*4)
+	for i := 0; i < pos.N; i++ {
+		ofs := m.PixOffset(pos.Pixel(i))
+		result = append(result, m.Pix[ofs:ofs+4]...)
+	}
+	return result
+}
+
+// highlightDuplicates returns a copy of the image where all blocks that
+// are byte-identical to another block are painted with a marker
+// color, or with the color of their group with -dup-colors. The
+// blockSize specifies the cipher block size in bytes.
+func highlightDuplicates(m *image.NRGBA, blockSize int) *image.NRGBA {
+	width := m.Rect.Dx()
+	height := m.Rect.Dy()
+
+	counts := make(map[string]int)
+	forEachBlock(width, height, blockSize/4, func(pos BlockPos) error {
+		counts[string(blockPixels(m, pos))]++
+		return nil
+	})
+
+	result := image.NewNRGBA(m.Rect)
+	copy(result.Pix, m.Pix)
+
+	forEachBlock(width, height, blockSize/4, func(pos BlockPos) error {
+		key := string(blockPixels(m, pos))
+		if counts[key] > 1 {
+			c := dupColor
+			if dupGroupColors {
+				c = groupColor(key)
+			}
+			for i := 0; i < pos.N; i++ {
+				x, y := pos.Pixel(i)
+				result.SetNRGBA(x, y, c)
+			}
+		}
+		return nil
+	})
+	return result
+}
+
+// dupRatio returns the fraction of the blocks of the image m that are
+// duplicates of an earlier block. The blockSize specifies the cipher
+// block size in bytes.
+func dupRatio(m *image.NRGBA, blockSize int) float64 {
+	seen := make(map[string]int)
+	var dups, total int
+
+	forEachBlock(m.Rect.Dx(), m.Rect.Dy(), blockSize/4,
+		func(pos BlockPos) error {
+			key := string(blockPixels(m, pos))
+			if seen[key] > 0 {
+				dups++
+			}
+			seen[key]++
+			total++
+			return nil
+		})
+	return float64(dups) / float64(total)
+}
@@ -0,0 +1,89 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+)
+
+// equalBlocksCommand implements the equal-blocks subcommand which
+// compares two ciphertext images block by block. With ECB and the
+// same key, the matching blocks reveal the positions where the
+// plaintext images are equal.
+func equalBlocksCommand(args []string) error {
+	fs := flag.NewFlagSet("equal-blocks", flag.ExitOnError)
+	blockSize := fs.Int("block-size", 16, "cipher block `size` in bytes")
+	output := fs.String("o", "equal-blocks.png", "output `file`")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		return errors.New("usage: equal-blocks [options] image1 image2")
+	}
+	if *blockSize <= 0 || *blockSize%4 != 0 {
+		return fmt.Errorf("block size must be a positive multiple of 4: %d",
+			*blockSize)
+	}
+	a, err := loadNRGBA(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, err := loadNRGBA(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	if !a.Rect.Eq(b.Rect) {
+		return fmt.Errorf("image sizes differ: %d×%d and %d×%d",
+			a.Rect.Dx(), a.Rect.Dy(), b.Rect.Dx(), b.Rect.Dy())
+	}
+
+	heatmap := image.NewNRGBA(a.Rect)
+	draw.Draw(heatmap, heatmap.Rect, image.Black, image.Point{}, draw.Src)
+
+	var matched, total int
+	forEachBlock(a.Rect.Dx(), a.Rect.Dy(), *blockSize/4,
+		func(pos BlockPos) error {
+			if equalBlocks(blockPixels(a, pos), blockPixels(b, pos)) {
+				for i := 0; i < pos.N; i++ {
+					x, y := pos.Pixel(i)
+					heatmap.SetNRGBA(x, y, color.NRGBA{
+						R: 0xff,
+						G: 0xff,
+						B: 0xff,
+						A: 0xff,
+					})
+				}
+				matched++
+			}
+			total++
+			return nil
+		})
+	progress.Printf("%d/%d blocks match\n", matched, total)
+
+	return save(heatmap, *output)
+}
+
+// loadNRGBA decodes the image file and returns it as an NRGBA image
+// with the origin at (0, 0).
+func loadNRGBA(path string) (*image.NRGBA, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m, _, err := image.Decode(f)
+	if err != nil {
+		return nil, decodeError(err)
+	}
+	return processImage(m, filterSpec{f: FilterCopy})
+}
@@ -0,0 +1,175 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"math"
+	"math/big"
+)
+
+// FilterFPE encrypts each block with the FF1 format-preserving
+// encryption, treating the block bytes as radix 256 numerals. The
+// encryption is keyed with the AES key and uses an empty tweak, so
+// like ECB it encrypts identical blocks identically.
+func FilterFPE(block []byte, seq int) error {
+	copy(block, newFF1(cipherAES256, 256).Encrypt(block, nil))
+	return nil
+}
+
+// FilterFPEDecrypt decrypts the FilterFPE blocks.
+func FilterFPEDecrypt(block []byte, seq int) error {
+	copy(block, newFF1(cipherAES256, 256).Decrypt(block, nil))
+	return nil
+}
+
+// ff1 implements the FF1 format-preserving encryption (NIST SP
+// 800-38G) of numeral strings with radix up to 256.
+type ff1 struct {
+	block cipher.Block
+	radix int
+}
+
+func newFF1(block cipher.Block, radix int) *ff1 {
+	return &ff1{
+		block: block,
+		radix: radix,
+	}
+}
+
+// Encrypt encrypts the numerals x with the tweak and returns the
+// encrypted numerals.
+func (f *ff1) Encrypt(x, tweak []byte) []byte {
+	return f.crypt(x, tweak, true)
+}
+
+// Decrypt decrypts the numerals x with the tweak and returns the
+// decrypted numerals.
+func (f *ff1) Decrypt(x, tweak []byte) []byte {
+	return f.crypt(x, tweak, false)
+}
+
+func (f *ff1) crypt(x, tweak []byte, encrypt bool) []byte {
+	n := len(x)
+	u := n / 2
+	v := n - u
+	a := append([]byte(nil), x[:u]...)
+	b := append([]byte(nil), x[u:]...)
+
+	bl := int(math.Ceil(math.Ceil(float64(v)*math.Log2(float64(f.radix))) / 8))
+	d := 4*((bl+3)/4) + 4
+
+	var p [aes.BlockSize]byte
+	p[0] = 1
+	p[1] = 2
+	p[2] = 1
+	p[3] = byte(f.radix >> 16)
+	p[4] = byte(f.radix >> 8)
+	p[5] = byte(f.radix)
+	p[6] = 10
+	p[7] = byte(u)
+	binary.BigEndian.PutUint32(p[8:12], uint32(n))
+	binary.BigEndian.PutUint32(p[12:16], uint32(len(tweak)))
+
+	radix := big.NewInt(int64(f.radix))
+	modU := new(big.Int).Exp(radix, big.NewInt(int64(u)), nil)
+	modV := new(big.Int).Exp(radix, big.NewInt(int64(v)), nil)
+
+	for j := 0; j < 10; j++ {
+		i := j
+		if !encrypt {
+			i = 9 - j
+		}
+		m, mod := u, modU
+		if i%2 == 1 {
+			m, mod = v, modV
+		}
+		// The round function input is the numeral string that is
+		// not modified in this round.
+		in := b
+		if !encrypt {
+			in = a
+		}
+		y := f.round(p[:], tweak, i, f.num(in), bl, d)
+
+		if encrypt {
+			c := new(big.Int).Add(f.num(a), y)
+			c.Mod(c, mod)
+			a, b = b, f.str(c, m)
+		} else {
+			c := new(big.Int).Sub(f.num(b), y)
+			c.Mod(c, mod)
+			a, b = f.str(c, m), a
+		}
+	}
+	return append(a, b...)
+}
+
+// round computes the FF1 round function value y of the round i.
+func (f *ff1) round(p, tweak []byte, i int, x *big.Int, bl, d int) *big.Int {
+	pad := (-len(tweak) - bl - 1) % aes.BlockSize
+	if pad < 0 {
+		pad += aes.BlockSize
+	}
+	q := make([]byte, 0, len(tweak)+pad+1+bl)
+	q = append(q, tweak...)
+	q = append(q, make([]byte, pad)...)
+	q = append(q, byte(i))
+	xb := x.Bytes()
+	q = append(q, make([]byte, bl-len(xb))...)
+	q = append(q, xb...)
+
+	// R = PRF(P || Q), the CBC-MAC with a zero IV.
+	var r [aes.BlockSize]byte
+	for _, data := range [][]byte{p, q} {
+		for ofs := 0; ofs < len(data); ofs += aes.BlockSize {
+			for k := 0; k < aes.BlockSize; k++ {
+				r[k] ^= data[ofs+k]
+			}
+			f.block.Encrypt(r[:], r[:])
+		}
+	}
+
+	s := append([]byte(nil), r[:]...)
+	for k := 1; len(s) < d; k++ {
+		var blk [aes.BlockSize]byte
+		binary.BigEndian.PutUint64(blk[8:], uint64(k))
+		for l := 0; l < aes.BlockSize; l++ {
+			blk[l] ^= r[l]
+		}
+		f.block.Encrypt(blk[:], blk[:])
+		s = append(s, blk[:]...)
+	}
+	return new(big.Int).SetBytes(s[:d])
+}
+
+// num returns the value of the numeral string x, most significant
+// numeral first.
+func (f *ff1) num(x []byte) *big.Int {
+	radix := big.NewInt(int64(f.radix))
+	result := new(big.Int)
+	for _, numeral := range x {
+		result.Mul(result, radix)
+		result.Add(result, big.NewInt(int64(numeral)))
+	}
+	return result
+}
+
+// str returns the m-numeral string of the value x.
+func (f *ff1) str(x *big.Int, m int) []byte {
+	radix := big.NewInt(int64(f.radix))
+	result := make([]byte, m)
+	x = new(big.Int).Set(x)
+	rem := new(big.Int)
+	for i := m - 1; i >= 0; i-- {
+		x.DivMod(x, radix, rem)
+		result[i] = byte(rem.Int64())
+	}
+	return result
+}
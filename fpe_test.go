@@ -0,0 +1,107 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"testing"
+)
+
+// NIST SP 800-38G FF1 samples with radix 10.
+var ff1Tests = []struct {
+	key      string
+	tweak    string
+	input    []byte
+	expected []byte
+}{
+	{
+		key:      "2b7e151628aed2a6abf7158809cf4f3c",
+		input:    []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+		expected: []byte{2, 4, 3, 3, 4, 7, 7, 4, 8, 4},
+	},
+	{
+		key:      "2b7e151628aed2a6abf7158809cf4f3c",
+		tweak:    "39383736353433323130",
+		input:    []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+		expected: []byte{6, 1, 2, 4, 2, 0, 0, 7, 7, 3},
+	},
+	{
+		key:      "2b7e151628aed2a6abf7158809cf4f3cef4359d8d580aa4f7f036d6f04fc6a94",
+		input:    []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+		expected: []byte{6, 6, 5, 7, 6, 6, 7, 0, 0, 9},
+	},
+	{
+		key:      "2b7e151628aed2a6abf7158809cf4f3cef4359d8d580aa4f7f036d6f04fc6a94",
+		tweak:    "39383736353433323130",
+		input:    []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+		expected: []byte{1, 0, 0, 1, 6, 2, 3, 4, 6, 3},
+	},
+}
+
+func TestFF1Samples(t *testing.T) {
+	for idx, test := range ff1Tests {
+		block, err := aes.NewCipher(decodeHex(t, test.key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := newFF1(block, 10)
+		tweak := decodeHex(t, test.tweak)
+
+		result := f.Encrypt(test.input, tweak)
+		if !bytes.Equal(result, test.expected) {
+			t.Errorf("sample %d: got %v, expected %v", idx, result,
+				test.expected)
+		}
+		result = f.Decrypt(test.expected, tweak)
+		if !bytes.Equal(result, test.input) {
+			t.Errorf("sample %d: decrypt: got %v, expected %v", idx, result,
+				test.input)
+		}
+	}
+}
+
+func TestFF1Radix256(t *testing.T) {
+	f := newFF1(cipherAES256, 256)
+
+	for _, n := range []int{2, 3, 15, 16, 17, 64} {
+		x := make([]byte, n)
+		for i := range x {
+			x[i] = byte(i*37 + n)
+		}
+		// All-zero and all-0xff numerals are the edge values.
+		for _, input := range [][]byte{x, make([]byte, n),
+			bytes.Repeat([]byte{0xff}, n)} {
+
+			ct := f.Encrypt(input, nil)
+			if len(ct) != n {
+				t.Fatalf("%d numerals: ciphertext length %d", n, len(ct))
+			}
+			if bytes.Equal(ct, input) {
+				t.Errorf("%d numerals: ciphertext equals plaintext %x",
+					n, input)
+			}
+			pt := f.Decrypt(ct, nil)
+			if !bytes.Equal(pt, input) {
+				t.Errorf("%d numerals: decrypted %x, expected %x",
+					n, pt, input)
+			}
+		}
+	}
+
+	block := testBlock(16)
+	ct := append([]byte(nil), block...)
+	if err := FilterFPE(ct, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := FilterFPEDecrypt(ct, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ct, block) {
+		t.Errorf("FF1 filter: decrypted %x, expected %x", ct, block)
+	}
+}
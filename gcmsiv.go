@@ -0,0 +1,143 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+
+	aead "github.com/google/tink/go/aead/subtle"
+)
+
+// gcmSIV implements the AES-GCM-SIV AEAD (RFC 8452) with
+// caller-provided nonces. The tink implementation always uses random
+// nonces which does not allow deriving the nonces from the block
+// sequence number.
+type gcmSIV struct {
+	key []byte
+}
+
+var errOpen = errors.New("gcm-siv: message authentication failed")
+
+func newGCMSIV(key []byte) (cipher.AEAD, error) {
+	switch len(key) {
+	case 16, 32:
+	default:
+		return nil, aes.KeySizeError(len(key))
+	}
+	return &gcmSIV{
+		key: key,
+	}, nil
+}
+
+func (g *gcmSIV) NonceSize() int {
+	return 12
+}
+
+func (g *gcmSIV) Overhead() int {
+	return 16
+}
+
+func (g *gcmSIV) Seal(dst, nonce, plaintext, data []byte) []byte {
+	authKey, enc := g.deriveKeys(nonce)
+	tag := g.tag(authKey, enc, nonce, plaintext, data)
+
+	ret := make([]byte, len(plaintext)+g.Overhead())
+	g.ctr(enc, tag[:], ret, plaintext)
+	copy(ret[len(plaintext):], tag[:])
+
+	return append(dst, ret...)
+}
+
+func (g *gcmSIV) Open(dst, nonce, ciphertext, data []byte) ([]byte, error) {
+	if len(ciphertext) < g.Overhead() {
+		return nil, errOpen
+	}
+	tag := ciphertext[len(ciphertext)-g.Overhead():]
+	ciphertext = ciphertext[:len(ciphertext)-g.Overhead()]
+
+	authKey, enc := g.deriveKeys(nonce)
+
+	plaintext := make([]byte, len(ciphertext))
+	g.ctr(enc, tag, plaintext, ciphertext)
+
+	expected := g.tag(authKey, enc, nonce, plaintext, data)
+	if !equalBlocks(expected[:], tag) {
+		return nil, errOpen
+	}
+	return append(dst, plaintext...), nil
+}
+
+// deriveKeys derives the per-nonce authentication key and encryption
+// cipher.
+func (g *gcmSIV) deriveKeys(nonce []byte) ([]byte, cipher.Block) {
+	var input, output [16]byte
+
+	if len(nonce) != g.NonceSize() {
+		panic("gcm-siv: invalid nonce size")
+	}
+
+	// The key was validated in newGCMSIV.
+	block, _ := aes.NewCipher(g.key)
+	copy(input[4:], nonce)
+
+	derived := make([]byte, 16+len(g.key))
+	for i := 0; i < len(derived)/8; i++ {
+		binary.LittleEndian.PutUint32(input[0:4], uint32(i))
+		block.Encrypt(output[:], input[:])
+		copy(derived[i*8:], output[0:8])
+	}
+	enc, _ := aes.NewCipher(derived[16:])
+
+	return derived[0:16], enc
+}
+
+func (g *gcmSIV) tag(authKey []byte, enc cipher.Block,
+	nonce, plaintext, data []byte) [16]byte {
+
+	var lengths [16]byte
+
+	binary.LittleEndian.PutUint64(lengths[0:8], uint64(len(data))*8)
+	binary.LittleEndian.PutUint64(lengths[8:16], uint64(len(plaintext))*8)
+
+	// The authentication key is always 16 bytes.
+	polyval, _ := aead.NewPolyval(authKey)
+	polyval.Update(data)
+	polyval.Update(plaintext)
+	polyval.Update(lengths[:])
+
+	tag := polyval.Finish()
+	for i := 0; i < len(nonce); i++ {
+		tag[i] ^= nonce[i]
+	}
+	tag[15] &= 0x7f
+	enc.Encrypt(tag[:], tag[:])
+
+	return tag
+}
+
+// ctr implements the AES-GCM-SIV counter mode which increments the
+// first 32 bits of the counter block as a little-endian integer.
+func (g *gcmSIV) ctr(enc cipher.Block, tag, dst, src []byte) {
+	var counter, keystream [16]byte
+
+	copy(counter[:], tag)
+	counter[15] |= 0x80
+	ctr := binary.LittleEndian.Uint32(counter[0:4])
+
+	for i := 0; i < len(src); i += 16 {
+		enc.Encrypt(keystream[:], counter[:])
+		ctr++
+		binary.LittleEndian.PutUint32(counter[0:4], ctr)
+
+		for j := 0; j < 16 && i+j < len(src); j++ {
+			dst[i+j] = src[i+j] ^ keystream[j]
+		}
+	}
+}
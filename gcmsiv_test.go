@@ -0,0 +1,122 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// RFC 8452 Appendix C test vectors.
+var gcmSIVTests = []struct {
+	key       string
+	nonce     string
+	plaintext string
+	aad       string
+	result    string
+}{
+	{
+		key:    "01000000000000000000000000000000",
+		nonce:  "030000000000000000000000",
+		result: "dc20e2d83f25705bb49e439eca56de25",
+	},
+	{
+		key:       "01000000000000000000000000000000",
+		nonce:     "030000000000000000000000",
+		plaintext: "0200000000000000",
+		aad:       "01",
+		result:    "1e6daba35669f4273b0a1a2560969cdf790d99759abd1508",
+	},
+	{
+		key:    "0100000000000000000000000000000000000000000000000000000000000000",
+		nonce:  "030000000000000000000000",
+		result: "07f5f4169bbf55a8400cd47ea6fd400f",
+	},
+	{
+		key:       "0100000000000000000000000000000000000000000000000000000000000000",
+		nonce:     "030000000000000000000000",
+		plaintext: "0100000000000000",
+		result:    "c2ef328e5c71c83b843122130f7364b761e0b97427e3df28",
+	},
+}
+
+func decodeHex(t *testing.T, val string) []byte {
+	data, err := hex.DecodeString(val)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestGCMSIVVectors(t *testing.T) {
+	for idx, test := range gcmSIVTests {
+		aead, err := newGCMSIV(decodeHex(t, test.key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		nonce := decodeHex(t, test.nonce)
+		plaintext := decodeHex(t, test.plaintext)
+		aad := decodeHex(t, test.aad)
+		expected := decodeHex(t, test.result)
+
+		result := aead.Seal(nil, nonce, plaintext, aad)
+		if !bytes.Equal(result, expected) {
+			t.Errorf("test %d: Seal: got %x, expected %x", idx, result,
+				expected)
+		}
+		opened, err := aead.Open(nil, nonce, expected, aad)
+		if err != nil {
+			t.Errorf("test %d: Open: %v", idx, err)
+		} else if !bytes.Equal(opened, plaintext) {
+			t.Errorf("test %d: Open: got %x, expected %x", idx, opened,
+				plaintext)
+		}
+	}
+}
+
+func TestGCMSIVRoundTrip(t *testing.T) {
+	aead, err := newGCMSIV(defaultKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := seqNonce(42, aead.NonceSize())
+	aad := []byte("crypto-modes")
+
+	for _, n := range []int{0, 1, 15, 16, 17, 64} {
+		plaintext := testBlock(n)
+		sealed := aead.Seal(nil, nonce, plaintext, aad)
+		if len(sealed) != n+aead.Overhead() {
+			t.Errorf("%d bytes: sealed length %d", n, len(sealed))
+		}
+		opened, err := aead.Open(nil, nonce, sealed, aad)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if !bytes.Equal(opened, plaintext) {
+			t.Errorf("%d bytes: opened %x, expected %x", n, opened, plaintext)
+		}
+
+		for i := range sealed {
+			tampered := append([]byte(nil), sealed...)
+			tampered[i] ^= 0x80
+			if _, err := aead.Open(nil, nonce, tampered, aad); err != errOpen {
+				t.Errorf("%d bytes: modified byte %d: got %v, expected %v",
+					n, i, err, errOpen)
+			}
+		}
+		if _, err := aead.Open(nil, seqNonce(43, aead.NonceSize()), sealed,
+			aad); err != errOpen {
+			t.Errorf("%d bytes: wrong nonce: got %v, expected %v",
+				n, err, errOpen)
+		}
+		if _, err := aead.Open(nil, nonce, sealed, nil); err != errOpen {
+			t.Errorf("%d bytes: wrong AAD: got %v, expected %v",
+				n, err, errOpen)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"testing"
+)
+
+func TestPenguin(t *testing.T) {
+	m := genImage(penguin, 256, 256, 64)
+
+	ecb, err := lookupFilter("AES-ECB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctr, err := lookupFilter("AES-CTR")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := processImage(m, ecb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := dupRatio(output, ecb.size()); v < 0.9 {
+		t.Errorf("AES-ECB: duplicate blocks %.2f%%, expected at least 90%%",
+			v*100)
+	}
+
+	output, err = processImage(m, ctr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := dupRatio(output, ctr.size()); v > 0.001 {
+		t.Errorf("AES-CTR: duplicate blocks %.2f%%, expected at most 0.1%%",
+			v*100)
+	}
+}
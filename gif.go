@@ -0,0 +1,66 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"os"
+)
+
+// processGIF applies all filters to each frame of the animated GIF
+// and writes the results as animated GIFs. The filtered frames are
+// mapped to the Plan 9 palette.
+func processGIF(path string, anim *gif.GIF, filters []filterSpec) error {
+	progress.Printf("%d\u00d7%d, %d frames\n",
+		anim.Config.Width, anim.Config.Height, len(anim.Image))
+
+	for _, filter := range filters {
+		output := &gif.GIF{
+			Delay:           anim.Delay,
+			LoopCount:       anim.LoopCount,
+			Disposal:        anim.Disposal,
+			Config:          anim.Config,
+			BackgroundIndex: anim.BackgroundIndex,
+		}
+		output.Config.ColorModel = nil
+
+		for idx, frame := range anim.Image {
+			m, err := processImage(frame, filter)
+			if err != nil {
+				return fmt.Errorf("frame %d: %w", idx, err)
+			}
+			paletted := image.NewPaletted(frame.Bounds(), palette.Plan9)
+			draw.Draw(paletted, paletted.Bounds(), m, image.Point{}, draw.Src)
+			output.Image = append(output.Image, paletted)
+		}
+
+		name := outputName(path, filter.name, "gif")
+		if err := saveGIF(output, name); err != nil {
+			return err
+		}
+		results = append(results, Result{
+			Input:  path,
+			Filter: filter.name,
+			Output: name,
+		})
+	}
+	return nil
+}
+
+func saveGIF(anim *gif.GIF, name string) error {
+	out, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	return gif.EncodeAll(out, anim)
+}
@@ -2,4 +2,13 @@ module github.com/markkurossi/crypto-modes
 
 go 1.18
 
-require github.com/google/tink/go v1.6.1
+require (
+	github.com/aead/skein v0.0.0-20160722084837-9365ae6e95d2
+	github.com/google/tink/go v1.6.1
+	golang.org/x/image v0.5.0
+)
+
+require (
+	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519 // indirect
+	golang.org/x/sys v0.0.0-20220722155257-8c9f86f7a55f // indirect
+)
@@ -0,0 +1,90 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"os"
+
+	"golang.org/x/image/tiff"
+)
+
+// processGray16 applies all filters to the 16-bit grayscale image and
+// writes the results as 16-bit grayscale TIFFs. The samples are
+// packed into the cipher blocks as big-endian 16-bit values.
+func processGray16(path string, m *image.Gray16, filters []filterSpec) error {
+	progress.Printf("%d×%d, 16-bit grayscale\n", m.Rect.Dx(), m.Rect.Dy())
+
+	for _, filter := range filters {
+		output, err := filterGray16(m, filter)
+		if err != nil {
+			return err
+		}
+		name := outputName(path, filter.name, "tiff")
+		if err := saveTIFF(output, name); err != nil {
+			return err
+		}
+		results = append(results, Result{
+			Input:  path,
+			Filter: filter.name,
+			Output: name,
+		})
+	}
+	return nil
+}
+
+// filterGray16 applies the filter to the 16-bit grayscale image m and
+// returns the filtered image.
+func filterGray16(m *image.Gray16, spec filterSpec) (*image.Gray16, error) {
+	bounds := m.Bounds()
+	width := bounds.Dx()
+	height := bounds.Dy()
+
+	output := image.NewGray16(image.Rect(0, 0, width, height))
+
+	filter := spec.instance()
+	block := make([]byte, spec.size())
+	var seq int
+
+	err := forEachBlock(width, height, len(block)/2, func(pos BlockPos) error {
+		if err := padBlock(block); err != nil {
+			return err
+		}
+		for i := 0; i < pos.N; i++ {
+			x, y := pos.Pixel(i)
+			c := m.Gray16At(bounds.Min.X+x, bounds.Min.Y+y)
+			binary.BigEndian.PutUint16(block[i*2:], c.Y)
+		}
+		if err := applyFilter(spec.name, filter, block, seq, pos); err != nil {
+			return err
+		}
+		for i := 0; i < pos.N; i++ {
+			x, y := pos.Pixel(i)
+			output.SetGray16(x, y, color.Gray16{
+				Y: binary.BigEndian.Uint16(block[i*2:]),
+			})
+		}
+		seq++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+func saveTIFF(m image.Image, name string) error {
+	out, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	return tiff.Encode(out, m, nil)
+}
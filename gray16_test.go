@@ -0,0 +1,89 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func gray16TestImage(width, height int) *image.Gray16 {
+	m := image.NewGray16(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			m.SetGray16(x, y, color.Gray16{Y: uint16(x*1031 + y*257)})
+		}
+	}
+	return m
+}
+
+func TestGray16RoundTrip(t *testing.T) {
+	// The width is a multiple of the 8-sample blocks so that all
+	// blocks decrypt exactly.
+	m := gray16TestImage(136, 72)
+
+	for _, name := range []string{"AES-ECB", "AES-CTR", "AES-CBC",
+		"Checkerboard", "FF1"} {
+
+		filter, err := lookupFilter(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ct, err := filterGray16(m, filter)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if bytes.Equal(ct.Pix, m.Pix) {
+			t.Errorf("%s: ciphertext equals plaintext", name)
+		}
+		pt, err := filterGray16(ct, filter.decryptor())
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(pt.Pix, m.Pix) {
+			t.Errorf("%s: decrypted image differs from the input", name)
+		}
+	}
+
+	// The TIFF output keeps the 16-bit samples.
+	filter, err := lookupFilter("AES-CTR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	name := filepath.Join(dir, "gray.tiff")
+	if err := saveTIFF(m, name); err != nil {
+		t.Fatal(err)
+	}
+	if err := processFile(name, []filterSpec{filter}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(outputName(name, filter.name, "tiff"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	output, _, err := image.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gray, ok := output.(*image.Gray16)
+	if !ok {
+		t.Fatalf("output is %T, expected *image.Gray16", output)
+	}
+	pt, err := filterGray16(gray, filter.decryptor())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pt.Pix, m.Pix) {
+		t.Errorf("decrypted TIFF output differs from the input")
+	}
+}
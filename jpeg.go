@@ -0,0 +1,34 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+)
+
+// jpegQuality is the -jpeg quality of the JPEG round trip of the
+// outputs. The round trip is disabled if the quality is 0.
+var jpegQuality int
+
+// jpegRoundTrip encodes the image m as a JPEG with the -jpeg quality
+// and returns the decoded image.
+func jpegRoundTrip(m *image.NRGBA) (*image.NRGBA, error) {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, m, &jpeg.Options{
+		Quality: jpegQuality,
+	})
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := jpeg.Decode(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return processImage(decoded, filterSpec{f: FilterCopy})
+}
@@ -0,0 +1,68 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"crypto/aes"
+	"image"
+	"os"
+)
+
+// writeKeystream writes the AES-CTR keystream of the image m into
+// the file name. The keystream is produced by encrypting an all-zero
+// plaintext, one 16-byte block for each encrypted block of the
+// image. The blocks passed through with -skip-transparent don't
+// advance the counter and their keystream blocks are all-zero.
+func writeKeystream(m image.Image, name string) error {
+	bounds := m.Bounds()
+	var blocks int
+	forEachBlock(bounds.Dx(), bounds.Dy(), aes.BlockSize/4,
+		func(pos BlockPos) error {
+			blocks++
+			return nil
+		})
+	if maxBlocks > 0 && blocks > maxBlocks {
+		blocks = maxBlocks
+	}
+
+	ctr := NewAESCTR()
+	keystream := make([]byte, blocks*aes.BlockSize)
+	record := func(block []byte, seq int) error {
+		return ctr(keystream[seq*aes.BlockSize:(seq+1)*aes.BlockSize], seq)
+	}
+	if _, err := processImage(m, filterSpec{f: record}); err != nil {
+		return err
+	}
+	return os.WriteFile(name, keystream, 0644)
+}
+
+// writePlaintext writes the plaintext blocks of the image m into the
+// file name, one block for each block of the image, indexed by the
+// sequence number as the keystream of writeKeystream. The partial
+// blocks at the end of rows are written with their padding and the
+// blocks passed through with -skip-transparent are included.
+func writePlaintext(m image.Image, name string) error {
+	skip := skipTransparent
+	skipTransparent = false
+	defer func() {
+		skipTransparent = skip
+	}()
+
+	var stream []byte
+	record := func(block []byte, seq int) error {
+		ofs := seq * len(block)
+		if end := ofs + len(block); end > len(stream) {
+			stream = append(stream, make([]byte, end-len(stream))...)
+		}
+		copy(stream[ofs:], block)
+		return nil
+	}
+	if _, err := processImage(m, filterSpec{f: record}); err != nil {
+		return err
+	}
+	return os.WriteFile(name, stream, 0644)
+}
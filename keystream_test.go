@@ -0,0 +1,133 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// transparentTestImage creates an image whose left part is fully
+// transparent and the right part opaque with varying colors.
+func transparentTestImage(width, height int) *image.NRGBA {
+	m := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBA{
+				R: byte(x * 7),
+				G: byte(y * 13),
+				B: byte(x ^ y),
+				A: 0xff,
+			}
+			if x < width/2 {
+				c.A = 0
+			}
+			m.SetNRGBA(x, y, c)
+		}
+	}
+	return m
+}
+
+func TestKeystreamSkipTransparent(t *testing.T) {
+	skipTransparent = true
+	defer func() {
+		skipTransparent = false
+	}()
+
+	m := transparentTestImage(37, 5)
+	name := filepath.Join(t.TempDir(), "keystream.bin")
+	if err := writeKeystream(m, name); err != nil {
+		t.Fatal(err)
+	}
+	keystream, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctr, err := lookupFilter("AES-CTR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, err := processImage(m, ctr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var seq int
+	forEachBlock(m.Rect.Dx(), m.Rect.Dy(), 4, func(pos BlockPos) error {
+		pt := blockPixels(m, pos)
+		ctb := blockPixels(ct, pos)
+		ks := keystream[seq*16:]
+		for i := range pt {
+			if pt[i]^ks[i] != ctb[i] {
+				t.Fatalf("block %d byte %d: plaintext %02x ^ keystream %02x "+
+					"!= ciphertext %02x", seq, i, pt[i], ks[i], ctb[i])
+			}
+		}
+		seq++
+		return nil
+	})
+}
+
+func TestPlaintextSkipTransparent(t *testing.T) {
+	skipTransparent = true
+	defer func() {
+		skipTransparent = false
+	}()
+
+	m := transparentTestImage(37, 5)
+	dir := t.TempDir()
+	var files [2][]byte
+	for i, write := range []func(image.Image, string) error{
+		writePlaintext, writeKeystream,
+	} {
+		name := filepath.Join(dir, fmt.Sprintf("%d.bin", i))
+		if err := write(m, name); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[i] = data
+	}
+	plaintext, keystream := files[0], files[1]
+	if len(plaintext) != len(keystream) {
+		t.Fatalf("plaintext is %d bytes, keystream %d bytes",
+			len(plaintext), len(keystream))
+	}
+	if !skipTransparent {
+		t.Errorf("writePlaintext didn't restore -skip-transparent")
+	}
+
+	ctr, err := lookupFilter("AES-CTR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, err := processImage(m, ctr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var seq int
+	forEachBlock(m.Rect.Dx(), m.Rect.Dy(), 4, func(pos BlockPos) error {
+		pt := plaintext[seq*16:]
+		ks := keystream[seq*16:]
+		for i, b := range blockPixels(ct, pos) {
+			if pt[i]^ks[i] != b {
+				t.Fatalf("block %d byte %d: plaintext %02x ^ keystream %02x "+
+					"!= ciphertext %02x", seq, i, pt[i], ks[i], b)
+			}
+		}
+		seq++
+		return nil
+	})
+}
@@ -0,0 +1,86 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// legendFilters are the color filters shown in the legend image.
+var legendFilters = []string{"red", "green", "blue"}
+
+// gradientImage creates a test gradient with the hues from left to
+// right, fading from full brightness to black from top to bottom.
+func gradientImage(width, height int) *image.NRGBA {
+	m := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		v := 1 - float64(y)/float64(height)
+		for x := 0; x < width; x++ {
+			r, g, b := hue(float64(x) * 6 / float64(width))
+			m.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(r * v * 0xff),
+				G: uint8(g * v * 0xff),
+				B: uint8(b * v * 0xff),
+				A: 0xff,
+			})
+		}
+	}
+	return m
+}
+
+// hue returns the fully saturated color of the hue h in the range
+// [0, 6).
+func hue(h float64) (r, g, b float64) {
+	i := int(h)
+	f := h - float64(i)
+	switch i {
+	case 0:
+		return 1, f, 0
+	case 1:
+		return 1 - f, 1, 0
+	case 2:
+		return 0, 1, f
+	case 3:
+		return 0, 1 - f, 1
+	case 4:
+		return f, 0, 1
+	default:
+		return 1, 0, 1 - f
+	}
+}
+
+// legendImage creates a reference image showing the test gradient
+// followed by the outputs of the color filters, side by side.
+func legendImage() (*image.NRGBA, error) {
+	const size = 128
+	const gap = 8
+
+	gradient := gradientImage(size, size)
+	panels := []*image.NRGBA{gradient}
+	for _, name := range legendFilters {
+		filter, err := lookupFilter(name)
+		if err != nil {
+			return nil, err
+		}
+		panel, err := processImage(gradient, filter)
+		if err != nil {
+			return nil, err
+		}
+		panels = append(panels, panel)
+	}
+
+	width := len(panels)*(size+gap) - gap
+	result := image.NewNRGBA(image.Rect(0, 0, width, size))
+	draw.Draw(result, result.Rect, image.White, image.Point{}, draw.Src)
+	for i, panel := range panels {
+		r := image.Rect(i*(size+gap), 0, i*(size+gap)+size, size)
+		draw.Draw(result, r, panel, image.Point{}, draw.Src)
+	}
+	return result, nil
+}
@@ -7,228 +7,1114 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"image"
 	"image/color"
+	"image/gif"
 	"image/png"
+	"io"
 	"log"
+	"math"
 	"os"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"text/template"
+	"time"
 
-	_ "image/jpeg"
-
+	"github.com/aead/skein/threefish"
 	"github.com/google/tink/go/kwp/subtle"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
 )
 
-type Filter func(block *[16]byte, seq int) error
+// Filter encrypts the block in place. The filters are
+// length-preserving: the ciphertext of a block is always as long as
+// the block.
+type Filter func(block []byte, seq int) error
+
+// PosFilter encrypts the block in place like Filter. It also receives
+// the position of the block in the image.
+type PosFilter func(block []byte, seq int, pos BlockPos) error
+
+// Sealer encrypts the block into a ciphertext that is longer than the
+// block, for example because it includes an authentication tag.
+type Sealer func(block []byte, seq int) ([]byte, error)
+
+// truncate creates a length-preserving filter from the sealer. The
+// filter keeps only the first len(block) bytes of the ciphertexts.
+func truncate(seal Sealer) Filter {
+	return func(block []byte, seq int) error {
+		ct, err := seal(block, seq)
+		if err != nil {
+			return err
+		}
+		if len(ct) < len(block) {
+			return fmt.Errorf("ciphertext length %d is shorter than "+
+				"block length %d", len(ct), len(block))
+		}
+		copy(block, ct)
+		return nil
+	}
+}
 
-func FilterCopy(block *[16]byte, seq int) error {
+func FilterCopy(block []byte, seq int) error {
 	return nil
 }
 
-func FilterRed(block *[16]byte, seq int) error {
-	for i := 0; i+4 <= len(block); i += 4 {
-		block[i+1] = 0
-		block[i+2] = 0
-	}
+func FilterRed(block []byte, seq int) error {
+	clearComponents(block, compG, compB)
 	return nil
 }
 
-func FilterGreen(block *[16]byte, seq int) error {
-	for i := 0; i+4 <= len(block); i += 4 {
-		block[i+0] = 0
-		block[i+2] = 0
-	}
+func FilterGreen(block []byte, seq int) error {
+	clearComponents(block, compR, compB)
 	return nil
 }
 
-func FilterBlue(block *[16]byte, seq int) error {
-	for i := 0; i+4 <= len(block); i += 4 {
-		block[i+0] = 0
-		block[i+1] = 0
-	}
+func FilterBlue(block []byte, seq int) error {
+	clearComponents(block, compR, compG)
 	return nil
 }
 
+// clearComponents zeroes the color components a and b of the block's
+// pixels. The last pixel of the block can be partial.
+func clearComponents(block []byte, a, b int) {
+	ao := compOffset(a)
+	bo := compOffset(b)
+	for i := 0; i < len(block); i += 4 {
+		if i+ao < len(block) {
+			block[i+ao] = 0
+		}
+		if i+bo < len(block) {
+			block[i+bo] = 0
+		}
+	}
+}
+
 var (
 	cipherAES256 cipher.Block
 	cipherGCM    cipher.AEAD
+	cipherGCMSIV cipher.AEAD
 	cipherAESKWP *subtle.KWP
 )
 
+// keyFingerprint identifies a non-default key in the output file
+// names. It is empty for the default key.
+var keyFingerprint string
+
+// currentKey is the key of the ciphers.
+var currentKey []byte
+
 func init() {
-	var err error
-	var key [32]byte
+	if err := setupKey(defaultKey()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// keyEnv is the environment variable holding the hex key that is used
+// if the -key or -keys flags are not set.
+const keyEnv = "CRYPTO_MODES_KEY"
 
+// parseKeys returns the keys of the -key or -keys flag values. If
+// neither is set, the key is read from the keyEnv environment
+// variable, and if it is not set either, the default key is used.
+func parseKeys(key, keyList string) ([][]byte, error) {
+	if len(key) > 0 && len(keyList) > 0 {
+		return nil, errors.New("-key and -keys are mutually exclusive")
+	}
+	if len(key) == 0 && len(keyList) == 0 {
+		key = os.Getenv(keyEnv)
+	}
+	var keys [][]byte
+	for _, val := range strings.Split(key+keyList, ",") {
+		if len(val) == 0 {
+			continue
+		}
+		k, err := hex.DecodeString(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key: %s", err)
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		keys = append(keys, defaultKey())
+	}
+	return keys, nil
+}
+
+func defaultKey() []byte {
+	key := make([]byte, 32)
 	for i := 0; i < len(key); i++ {
 		key[i] = byte(i)
 	}
+	return key
+}
+
+// setupKey creates the ciphers with the 256-bit key.
+func setupKey(key []byte) error {
+	var err error
+
+	if len(key) != 32 {
+		return fmt.Errorf("invalid key length %d, expected 32 bytes", len(key))
+	}
 
-	cipherAES256, err = aes.NewCipher(key[:])
+	cipherAES256, err = aes.NewCipher(key)
 	if err != nil {
-		log.Fatalf("failed to create AES256: %s", err)
+		return fmt.Errorf("failed to create AES256: %s", err)
 	}
 
 	cipherGCM, err = cipher.NewGCM(cipherAES256)
 	if err != nil {
-		log.Fatalf("failed to create AES256-GCM: %s", err)
+		return fmt.Errorf("failed to create AES256-GCM: %s", err)
 	}
 
-	cipherAESKWP, err = subtle.NewKWP(key[:])
+	cipherGCMSIV, err = newGCMSIV(key)
 	if err != nil {
-		log.Fatalf("failed to create AES256-KWP: %s", err)
+		return fmt.Errorf("failed to create AES256-GCM-SIV: %s", err)
 	}
 
+	cipherAESKWP, err = subtle.NewKWP(key)
+	if err != nil {
+		return fmt.Errorf("failed to create AES256-KWP: %s", err)
+	}
+
+	if err := setupThreefish(key); err != nil {
+		return fmt.Errorf("failed to create Threefish-512: %s", err)
+	}
+
+	hmacKey = deriveHMACKey(key)
+	currentKey = key
+
+	if bytes.Equal(key, defaultKey()) {
+		keyFingerprint = ""
+	} else {
+		sum := sha256.Sum256(key)
+		keyFingerprint = hex.EncodeToString(sum[:4])
+	}
+	return nil
 }
 
-func AESECB(block *[16]byte, seq int) error {
-	cipherAES256.Encrypt(block[:], block[:])
+func AESECB(block []byte, seq int) error {
+	cipherAES256.Encrypt(block, block)
 	return nil
 }
 
-func AESGCM(block *[16]byte, seq int) error {
-	var nonce [16]byte
+func AESECBDecrypt(block []byte, seq int) error {
+	cipherAES256.Decrypt(block, block)
+	return nil
+}
 
-	binary.BigEndian.PutUint64(nonce[0:8], uint64(seq))
-	dst := cipherGCM.Seal(nil, nonce[0:cipherGCM.NonceSize()], block[:], nil)
+// FilterHighNibble encrypts the 4 most significant bits of each byte
+// and keeps the low bits intact.
+func FilterHighNibble(block []byte, seq int) error {
+	aesECBMasked(block, 0xf0)
+	return nil
+}
 
-	copy(block[:], dst)
+// FilterBitplane encrypts the bits of each byte selected with the
+// -bits flag and keeps the remaining bits intact.
+func FilterBitplane(block []byte, seq int) error {
+	aesECBMasked(block, bitMask)
 	return nil
 }
 
-func AESKWP(block *[16]byte, seq int) error {
-	result, err := cipherAESKWP.Wrap(block[:])
-	if err != nil {
-		return err
+func aesECBMasked(block []byte, mask byte) {
+	var ct [16]byte
+
+	for i := 0; i < len(block); i++ {
+		ct[i] = block[i] & mask
+	}
+	cipherAES256.Encrypt(ct[:], ct[:])
+	for i := 0; i < len(block); i++ {
+		block[i] = block[i]&^mask | ct[i]&mask
 	}
-	copy(block[:], result)
-	return nil
 }
 
-func AESKWPFixedIVs(block *[16]byte, seq int) error {
+// parseBits parses the bit range hi-lo (or a single bit) and returns
+// the corresponding byte mask.
+func parseBits(val string) (byte, error) {
+	var hi, lo uint
+	n, err := fmt.Sscanf(val, "%d-%d", &hi, &lo)
+	if n == 1 {
+		lo = hi
+	} else if err != nil {
+		return 0, fmt.Errorf("invalid bit range: %s", val)
+	}
+	if hi > 7 || lo > hi {
+		return 0, fmt.Errorf("invalid bit range: %s", val)
+	}
+	return byte(0xff<<lo) & byte(0xff>>(7-hi)), nil
+}
+
+// AESGCM encrypts each block with AES-GCM. The 12-byte nonce is the
+// big-endian seq in its first 8 bytes followed by zeros, so every
+// block of an image has a unique nonce and identical plaintext blocks
+// encrypt to different ciphertexts.
+func AESGCM(block []byte, seq int) ([]byte, error) {
+	return cipherGCM.Seal(nil, seqNonce(seq, cipherGCM.NonceSize()), block,
+		nil), nil
+}
+
+// seqNonce creates the size-byte nonce of the block seq. It holds the
+// big-endian seq in its first 8 bytes followed by zeros.
+func seqNonce(seq, size int) []byte {
+	nonce := make([]byte, size)
+	binary.BigEndian.PutUint64(nonce[0:8], uint64(seq))
+	return nonce
+}
+
+// AESGCMRandomNonce encrypts each block with AES-GCM using a random
+// nonce, so the encryptions of the same image differ.
+func AESGCMRandomNonce(block []byte, seq int) ([]byte, error) {
+	nonce := make([]byte, cipherGCM.NonceSize())
+	if _, err := io.ReadFull(RandReader, nonce); err != nil {
+		return nil, err
+	}
+	return cipherGCM.Seal(nil, nonce, block, nil), nil
+}
+
+func AESGCMSIV(block []byte, seq int) ([]byte, error) {
+	return cipherGCMSIV.Seal(nil, seqNonce(seq, cipherGCMSIV.NonceSize()),
+		block, nil), nil
+}
+
+func AESKWP(block []byte, seq int) ([]byte, error) {
+	return cipherAESKWP.Wrap(block)
+}
+
+// ivModulus is the number of distinct IVs of the AES-KWP-FixedIVs and
+// AES-KWP-RandomFixedIVs filters, set with the -iv-mod flag.
+var ivModulus = 8
+
+// AESKWPFixedIVs wraps each block with one of the -iv-mod fixed IVs,
+// selected by seq.
+func AESKWPFixedIVs(block []byte, seq int) ([]byte, error) {
 	var plaintext [32]byte
 
-	ivb := byte(seq % 8)
+	ivb := byte(seq % ivModulus)
 	for i := 0; i < 16; i++ {
 		plaintext[i] = ivb
 	}
-	copy(plaintext[16:], block[:])
+	copy(plaintext[16:], block)
 
 	result, err := cipherAESKWP.Wrap(plaintext[:])
 	if err != nil {
-		return err
+		return nil, err
 	}
-	copy(block[:], result[16:])
-	return nil
+	return result[16:], nil
 }
 
-func AESKWPRandomFixedIVs(block *[16]byte, seq int) error {
+// AESKWPRandomFixedIVs wraps each block with one of the -iv-mod fixed
+// IVs, selected at random.
+func AESKWPRandomFixedIVs(block []byte, seq int) ([]byte, error) {
 	var plaintext [32]byte
 	var iv [1]byte
 
-	_, err := rand.Read(iv[:])
+	_, err := io.ReadFull(RandReader, iv[:])
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	ivb := byte(iv[0] % 8)
+	ivb := byte(int(iv[0]) % ivModulus)
 	for i := 0; i < 16; i++ {
 		plaintext[i] = ivb
 	}
-	copy(plaintext[16:], block[:])
+	copy(plaintext[16:], block)
 
 	result, err := cipherAESKWP.Wrap(plaintext[:])
 	if err != nil {
-		return err
+		return nil, err
 	}
-	copy(block[:], result[16:])
-	return nil
+	return result[16:], nil
 }
 
-func AESKWPRandomIV(block *[16]byte, seq int) error {
+func AESKWPRandomIV(block []byte, seq int) ([]byte, error) {
 	var plaintext [32]byte
 
-	_, err := rand.Read(plaintext[0:16])
+	_, err := io.ReadFull(RandReader, plaintext[0:16])
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	copy(plaintext[16:], block[:])
+	copy(plaintext[16:], block)
 
 	result, err := cipherAESKWP.Wrap(plaintext[:])
 	if err != nil {
-		return err
+		return nil, err
 	}
-	copy(block[:], result[16:])
-	return nil
+	return result[16:], nil
+}
+
+// progress logs human-readable progress messages to stderr. The
+// results, such as the JSON report, are printed to stdout.
+var progress = log.New(os.Stderr, "", 0)
+
+// RandReader is the source of randomness for the filters using random
+// IVs and for the random padding.
+var RandReader io.Reader = rand.Reader
+
+type filterSpec struct {
+	name    string
+	f       Filter
+	inverse Filter
+
+	// The cipher block size in bytes, 16 if unset. The block size must
+	// be a multiple of the pixel size 4.
+	blockSize int
+
+	// Stateful filters are created with the newFilter and newInverse
+	// functions for each processed image.
+	newFilter  func() Filter
+	newInverse func() Filter
+
+	// Expanding filters produce ciphertexts longer than the block with
+	// the seal function. The output images keep only the first block
+	// size bytes of the ciphertexts.
+	seal Sealer
+
+	// The filter outputs plaintext images instead of ciphertext.
+	plaintext bool
+
+	// The filter processes whole pixels. With -rgb, it is applied to
+	// each pixel instead of the RGB blocks which are not aligned to the
+	// pixels.
+	pixels bool
+
+	// Position-dependent filters are created with the newPosFilter
+	// and newPosInverse functions for each processed image.
+	newPosFilter  func() PosFilter
+	newPosInverse func() PosFilter
 }
 
-var filters = []struct {
-	name string
-	f    Filter
-}{
+// instance returns the filter function for processing an image. The
+// position-independent filters ignore the block position.
+func (spec filterSpec) instance() PosFilter {
+	if spec.newPosFilter != nil {
+		return spec.newPosFilter()
+	}
+	filter := spec.f
+	if spec.newFilter != nil {
+		filter = spec.newFilter()
+	} else if spec.seal != nil {
+		filter = truncate(spec.seal)
+	}
+	return func(block []byte, seq int, pos BlockPos) error {
+		return filter(block, seq)
+	}
+}
+
+// size returns the filter's block size in bytes.
+func (spec filterSpec) size() int {
+	if spec.blockSize == 0 {
+		return aes.BlockSize
+	}
+	return spec.blockSize
+}
+
+// invertible tests if the filter's output images can be decrypted.
+func (spec filterSpec) invertible() bool {
+	return spec.inverse != nil || spec.newInverse != nil ||
+		spec.newPosInverse != nil
+}
+
+// expanding tests if the filter's ciphertexts are truncated in the
+// output images.
+func (spec filterSpec) expanding() bool {
+	return spec.seal != nil
+}
+
+// decryptor returns the filter that decrypts the filter's output
+// images.
+func (spec filterSpec) decryptor() filterSpec {
+	return filterSpec{
+		name:      spec.name + "-decrypted",
+		f:         spec.inverse,
+		newFilter: spec.newInverse,
+		blockSize: spec.blockSize,
+		plaintext: true,
+
+		newPosFilter: spec.newPosInverse,
+	}
+}
+
+// pngLevel returns the PNG compression level of the filter's output
+// images. Unless set with -png-level, the ciphertext images use
+// BestSpeed since they don't compress anyway.
+func (spec filterSpec) pngLevel() png.CompressionLevel {
+	if !pngLevelSet && !spec.plaintext {
+		return png.BestSpeed
+	}
+	return pngLevel
+}
+
+var filters = []filterSpec{
+	{
+		name:      "red",
+		f:         FilterRed,
+		plaintext: true,
+		pixels:    true,
+	},
+	{
+		name:      "green",
+		f:         FilterGreen,
+		plaintext: true,
+		pixels:    true,
+	},
+	{
+		name:      "blue",
+		f:         FilterBlue,
+		plaintext: true,
+		pixels:    true,
+	},
+	{
+		name:    "AES-ECB",
+		f:       AESECB,
+		inverse: AESECBDecrypt,
+	},
+	{
+		name: "AES-ECB-HighNibble",
+		f:    FilterHighNibble,
+	},
+	{
+		name: "AES-ECB-Bits",
+		f:    FilterBitplane,
+	},
 	{
-		name: "red",
-		f:    FilterRed,
+		name:       "AES-CTR",
+		newFilter:  NewAESCTR,
+		newInverse: NewAESCTR,
 	},
 	{
-		name: "green",
-		f:    FilterGreen,
+		name:       "AES-CTR-Wrap",
+		newFilter:  NewAESCTRWrap,
+		newInverse: NewAESCTRWrap,
 	},
 	{
-		name: "blue",
-		f:    FilterBlue,
+		name:       "AES-CBC",
+		newFilter:  NewAESCBC,
+		newInverse: NewAESCBCDecrypt,
 	},
 	{
-		name: "AES-ECB",
-		f:    AESECB,
+		name: "AES-CBC-HMAC",
+		seal: AESCBCHMAC,
 	},
 	{
 		name: "AES-GCM",
-		f:    AESGCM,
+		seal: AESGCM,
+	},
+	{
+		name: "AES-GCM-RandomNonce",
+		seal: AESGCMRandomNonce,
+	},
+	{
+		name: "AES-GCM-SIV",
+		seal: AESGCMSIV,
 	},
 	{
 		name: "AES-KWP",
-		f:    AESKWP,
+		seal: AESKWP,
+	},
+	{
+		name:      "Threefish-512-ECB",
+		f:         Threefish512,
+		inverse:   Threefish512Decrypt,
+		blockSize: threefish.BlockSize512,
+	},
+	{
+		name:          "Checkerboard",
+		newPosFilter:  NewCheckerboard,
+		newPosInverse: NewCheckerboardDecrypt,
+	},
+	{
+		name:    "FF1",
+		f:       FilterFPE,
+		inverse: FilterFPEDecrypt,
 	},
 	{
 		name: "AES-KWP-FixedIVs",
-		f:    AESKWPFixedIVs,
+		seal: AESKWPFixedIVs,
 	},
 	{
 		name: "AES-KWP-RandomFixedIVs",
-		f:    AESKWPRandomFixedIVs,
+		seal: AESKWPRandomFixedIVs,
 	},
 	{
 		name: "AES-KWP-RandomIV",
-		f:    AESKWPRandomIV,
+		seal: AESKWPRandomIV,
 	},
 }
 
+var (
+	padByte   byte
+	padRandom bool
+	dryRun    bool
+	bitMask   byte
+	maxBlocks int
+
+	compareBaseline bool
+	computePSNR     bool
+	highlightDups   bool
+	stream          bool
+	columnOrder     bool
+	avalancheMap    bool
+	wrongKey        bool
+	debugCBC        bool
+	inPlace         bool
+	verify          bool
+	delta           bool
+	skipTransparent bool
+	computeDupRatio bool
+	keystreamOut    bool
+	emitPlaintext   bool
+	bitplanes       bool
+	cbcPadding      bool
+
+	pngLevel    = png.DefaultCompression
+	pngLevelSet bool
+)
+
+// pngLevels map the -png-level names to the PNG compression levels.
+var pngLevels = map[string]png.CompressionLevel{
+	"default": png.DefaultCompression,
+	"none":    png.NoCompression,
+	"speed":   png.BestSpeed,
+	"best":    png.BestCompression,
+}
+
 func main() {
+	pad := flag.Uint("pad-byte", 0, "padding `byte` for partial blocks")
+	flag.BoolVar(&padRandom, "pad-random", false, "use random padding")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"list output files without processing images")
+	flag.IntVar(&maxBlocks, "max-blocks", 0,
+		"filter only the first `N` blocks and copy the rest unchanged")
+	flag.BoolVar(&compareBaseline, "baseline", false,
+		"compare outputs against a random baseline image")
+	flag.BoolVar(&computePSNR, "psnr", false,
+		"compute the PSNR of outputs relative to the input image")
+	flag.BoolVar(&computeDupRatio, "dup-ratio", false,
+		"report the fraction of duplicate output blocks")
+	flag.BoolVar(&highlightDups, "highlight-dups", false,
+		"write images highlighting duplicate output blocks")
+	flag.BoolVar(&dupGroupColors, "dup-colors", false,
+		"color each group of duplicate blocks differently in -highlight-dups")
+	flag.BoolVar(&avalancheMap, "avalanche", false,
+		"write maps of the blocks changed by flipping one input bit")
+	flag.BoolVar(&wrongKey, "wrong-key", false,
+		"write outputs decrypted with a slightly wrong key")
+	flag.BoolVar(&debugCBC, "debug-cbc", false,
+		"write an image of the AES-CBC block cipher inputs")
+	flag.BoolVar(&verify, "verify", false,
+		"verify that the outputs decrypt back to the input image")
+	flag.BoolVar(&skipTransparent, "skip-transparent", false,
+		"pass fully transparent blocks through unencrypted")
+	flag.Float64Var(&scaleFactor, "scale", scaleFactor,
+		"scale the input images by the `factor` before processing")
+	interp := flag.String("interp", "nearest",
+		"scaling `interpolation`: nearest, bilinear")
+	pixOrder := flag.String("pixel-order", "rgba",
+		"byte `order` of the pixels in the blocks: rgba, bgra, argb, abgr")
+	flag.BoolVar(&rgbMode, "rgb", false,
+		"pack only the RGB components, 3 bytes per pixel, into blocks")
+	flag.BoolVar(&skipExisting, "skip-existing", false,
+		"skip inputs whose outputs are newer than the input")
+	flag.IntVar(&jpegQuality, "jpeg", 0,
+		"write the outputs recompressed as JPEG of the `quality` 1-100, "+
+			"and decrypted from it")
+	flag.BoolVar(&delta, "delta", false,
+		"encrypt the difference of each input image to the previous one")
+	flag.IntVar(&ctrWrap, "ctr-wrap", ctrWrap,
+		"number of `blocks` after which the AES-CTR-Wrap counter wraps")
+	flag.IntVar(&ivModulus, "iv-mod", ivModulus,
+		"`number` of distinct IVs of the AES-KWP fixed IV filters (1-256)")
+	flag.BoolVar(&cbcPadding, "cbc-padded", false,
+		"write the image encrypted with PKCS #7 padded AES-CBC and its "+
+			"block structure")
+	flag.BoolVar(&bitplanes, "bitplanes", false,
+		"write an image of the luminance bit-planes encrypted with AES-ECB")
+	flag.BoolVar(&emitPlaintext, "emit-plaintext", false,
+		"write the plaintext blocks of each input to a .bin file")
+	flag.BoolVar(&keystreamOut, "keystream-out", false,
+		"write the AES-CTR keystream of each input to a .bin file")
+	flag.BoolVar(&stream, "stream", false,
+		"write outputs row by row without in-memory output images")
+	jsonReport := flag.Bool("json", false, "print a JSON report of the results")
+	filterList := flag.String("filters", "",
+		"comma-separated list of `filters` to apply (default all)")
+	flag.BoolVar(&inPlace, "inplace", false,
+		"overwrite the input image with the output of the selected filter")
+	force := flag.Bool("force", false, "allow -inplace to overwrite inputs")
+	decrypt := flag.String("decrypt", "",
+		"decrypt input images encrypted with the `filter`")
+	pipeline := flag.String("pipeline", "",
+		"apply the comma-separated `filters` in order")
+	bits := flag.String("bits", "7-4",
+		"bit `range` hi-lo to encrypt with AES-ECB-Bits")
+	order := flag.String("order", "row",
+		"pixel `order` for forming cipher blocks: row, column")
+	key := flag.String("key", "",
+		"256-bit AES `key` in hex (default $"+keyEnv+" or 00..1f)")
+	keyList := flag.String("keys", "",
+		"comma-separated hex `keys`, each producing its own outputs")
+	endian := flag.String("counter-endian", "big",
+		"AES-CTR counter byte `order`: big, little")
+	quiet := flag.Bool("quiet", false, "suppress progress messages")
+	level := flag.String("png-level", "",
+		"PNG compression `level`: default, none, speed, best "+
+			"(default speed for ciphertext outputs)")
+	flag.StringVar(&watermarkCorner, "watermark", "",
+		"label outputs with the filter and key in the `corner`: "+
+			strings.Join(watermarkCorners, ", "))
+	outTmpl := flag.String("out-template", defaultOutTemplate,
+		"output file name `template` with the fields .Base, .Filter, .Ext, "+
+			"and .KeyFP")
+	selftest := flag.Bool("selftest", false,
+		"run the cipher known-answer tests and exit")
+	timeout := flag.Duration("timeout", 0,
+		"abort the run after the `duration`, 0 for no timeout")
+	cpuProfile := flag.String("cpuprofile", "", "write CPU profile to `file`")
+	memProfile := flag.String("memprofile", "", "write memory profile to `file`")
+	legend := flag.String("legend", "",
+		"write a legend image of the color filters to `file`")
 	flag.Parse()
 	log.SetFlags(0)
 
-	for _, arg := range flag.Args() {
-		err := processFile(arg)
+	if *quiet {
+		progress.SetOutput(io.Discard)
+	}
+
+	if *selftest {
+		if err := selfTest(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if flag.NArg() > 0 && flag.Arg(0) == "gen" {
+		if err := genCommand(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if flag.NArg() > 0 && flag.Arg(0) == "determinism" {
+		if err := determinismCommand(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if flag.NArg() > 0 && flag.Arg(0) == "equal-blocks" {
+		if err := equalBlocksCommand(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var err error
+	outTemplate, err = parseOutTemplate(*outTmpl)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var ok bool
+	scaler, ok = interpolators[*interp]
+	if !ok {
+		log.Fatalf("invalid interpolation: %s\n", *interp)
+	}
+	if scaleFactor <= 0 {
+		log.Fatalf("invalid scale factor: %v\n", scaleFactor)
+	}
+	pixelOrder, ok = pixelOrders[*pixOrder]
+	if !ok {
+		log.Fatalf("invalid pixel byte order: %s\n", *pixOrder)
+	}
+	if rgbMode && *pixOrder != "rgba" {
+		log.Fatal("-rgb can't be used with -pixel-order")
+	}
+	if jpegQuality < 0 || jpegQuality > 100 {
+		log.Fatalf("invalid JPEG quality: %d\n", jpegQuality)
+	}
+	if ctrWrap < 1 {
+		log.Fatalf("invalid counter wrap: %d\n", ctrWrap)
+	}
+	if ivModulus < 1 || ivModulus > 256 {
+		log.Fatalf("invalid IV modulus: %d\n", ivModulus)
+	}
+	if *pad > 0xff {
+		log.Fatalf("invalid padding byte: %d\n", *pad)
+	}
+	padByte = byte(*pad)
+
+	if len(*level) > 0 {
+		var ok bool
+		pngLevel, ok = pngLevels[*level]
+		if !ok {
+			log.Fatalf("invalid PNG compression level: %s\n", *level)
+		}
+		pngLevelSet = true
+	}
+
+	switch *order {
+	case "row":
+	case "column":
+		columnOrder = true
+	default:
+		log.Fatalf("invalid pixel order: %s\n", *order)
+	}
+	if stream && columnOrder {
+		log.Fatal("-stream requires row order")
+	}
+	if rgbMode && (highlightDups || computeDupRatio || avalancheMap ||
+		verify || skipTransparent || debugCBC || keystreamOut) {
+		log.Fatal("-rgb can't be used with -highlight-dups, -dup-ratio, " +
+			"-avalanche, -verify, -skip-transparent, -debug-cbc, or " +
+			"-keystream-out")
+	}
+	if len(watermarkCorner) > 0 {
+		var ok bool
+		for _, corner := range watermarkCorners {
+			if corner == watermarkCorner {
+				ok = true
+			}
+		}
+		if !ok {
+			log.Fatalf("invalid watermark corner: %s\n", watermarkCorner)
+		}
+	}
+	if stream && (compareBaseline || computePSNR || highlightDups ||
+		computeDupRatio || avalancheMap || wrongKey || verify ||
+		len(watermarkCorner) > 0 || jpegQuality > 0) {
+		log.Fatal("-stream can't be used with -baseline, -psnr, " +
+			"-highlight-dups, -dup-ratio, -avalanche, -wrong-key, " +
+			"-verify, -watermark, or -jpeg")
+	}
+
+	keys, err := parseKeys(*key, *keyList)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	bitMask, err = parseBits(*bits)
+	if err != nil {
+		log.Fatal(err)
+	}
+	counterLittleEndian, err = parseEndian(*endian)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	selected := filters
+	if len(*filterList) > 0 {
+		if len(*decrypt) > 0 || len(*pipeline) > 0 {
+			log.Fatal("-filters can't be used with -decrypt or -pipeline")
+		}
+		selected = nil
+		for _, name := range strings.Split(*filterList, ",") {
+			filter, err := lookupFilter(name)
+			if err != nil {
+				log.Fatal(err)
+			}
+			selected = append(selected, filter)
+		}
+	}
+	if len(*decrypt) > 0 {
+		filter, err := lookupFilter(*decrypt)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !filter.invertible() {
+			log.Fatalf("filter %s can't be decrypted from its output image\n",
+				filter.name)
+		}
+		selected = []filterSpec{filter.decryptor()}
+	}
+
+	if len(*pipeline) > 0 {
+		if len(*decrypt) > 0 {
+			log.Fatal("-pipeline and -decrypt are mutually exclusive")
+		}
+		filter, err := newPipeline(strings.Split(*pipeline, ","))
+		if err != nil {
+			log.Fatal(err)
+		}
+		selected = []filterSpec{filter}
+	}
+
+	if inPlace {
+		if len(selected) != 1 {
+			log.Fatal("-inplace requires exactly one filter")
+		}
+		if len(keys) != 1 {
+			log.Fatal("-inplace can't be used with multiple keys")
+		}
+		if !*force {
+			log.Fatal("-inplace overwrites the input files, use -force " +
+				"to confirm")
+		}
+		if skipExisting {
+			log.Fatal("-inplace and -skip-existing are mutually exclusive")
+		}
+	}
+	if padRandom && emitPlaintext {
+		// The random padding of the plaintext output would differ
+		// from the padding the filters encrypt.
+		log.Fatal("-pad-random and -emit-plaintext are mutually exclusive")
+	}
+	if delta && skipExisting {
+		// The delta outputs depend on the previous frames which are
+		// not decoded for the skipped files.
+		log.Fatal("-delta and -skip-existing are mutually exclusive")
+	}
+
+	err = run(runConfig{
+		keys:       keys,
+		filters:    selected,
+		legend:     *legend,
+		timeout:    *timeout,
+		jsonReport: *jsonReport,
+		cpuProfile: *cpuProfile,
+		memProfile: *memProfile,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runConfig holds the flag values of a processing run.
+type runConfig struct {
+	keys       [][]byte
+	filters    []filterSpec
+	legend     string
+	timeout    time.Duration
+	jsonReport bool
+	cpuProfile string
+	memProfile string
+}
+
+// run processes the input files with all keys and filters. The CPU
+// profile is stopped and its file closed before run returns, also
+// when the run fails.
+func run(cfg runConfig) (err error) {
+	if len(cfg.cpuProfile) > 0 {
+		f, cerr := os.Create(cfg.cpuProfile)
+		if cerr != nil {
+			return cerr
+		}
+		if cerr := pprof.StartCPUProfile(f); cerr != nil {
+			f.Close()
+			return cerr
+		}
+		defer func() {
+			pprof.StopCPUProfile()
+			if cerr := f.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}()
+	}
+
+	if len(cfg.legend) > 0 {
+		m, err := legendImage()
+		if err != nil {
+			return err
+		}
+		if err := save(m, cfg.legend); err != nil {
+			return err
+		}
+	}
+
+	if cfg.timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(runCtx, cfg.timeout)
+		defer cancel()
+	}
+
+	for _, k := range cfg.keys {
+		if err := setupKey(k); err != nil {
+			return err
+		}
+		prevFrame = nil
+		for _, arg := range flag.Args() {
+			err := processFile(arg, cfg.filters)
+			if err != nil && flag.NArg() > 1 &&
+				errors.Is(err, image.ErrFormat) {
+				log.Printf("skipping file '%s': %s\n", arg, err)
+				continue
+			}
+			if errors.Is(err, context.DeadlineExceeded) {
+				return fmt.Errorf("timeout %s exceeded while processing "+
+					"file '%s'", cfg.timeout, arg)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to process file '%s': %s", arg, err)
+			}
+		}
+	}
+	if cfg.jsonReport {
+		if err := writeReport(os.Stdout); err != nil {
+			return err
+		}
+	}
+	if len(cfg.memProfile) > 0 {
+		f, err := os.Create(cfg.memProfile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		runtime.GC()
+		if err := pprof.WriteHeapProfile(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filterAliases map the short lowercase filter names to the
+// registered filters.
+var filterAliases = map[string]string{
+	"ecb":       "AES-ECB",
+	"ctr":       "AES-CTR",
+	"cbc":       "AES-CBC",
+	"cbc-hmac":  "AES-CBC-HMAC",
+	"gcm":       "AES-GCM",
+	"gcm-siv":   "AES-GCM-SIV",
+	"siv":       "AES-GCM-SIV",
+	"kwp":       "AES-KWP",
+	"threefish": "Threefish-512-ECB",
+}
+
+// lookupFilter finds the filter by its name or alias. The names are
+// case-insensitive.
+func lookupFilter(name string) (filterSpec, error) {
+	if alias, ok := filterAliases[strings.ToLower(name)]; ok {
+		name = alias
+	}
+	for _, filter := range filters {
+		if strings.EqualFold(filter.name, name) {
+			return filter, nil
+		}
+	}
+	return filterSpec{}, fmt.Errorf("unknown filter: %s", name)
+}
+
+// newPipeline creates a filter that applies the named filters in
+// order to each block. Each stateful filter of the pipeline has its
+// own state.
+func newPipeline(names []string) (filterSpec, error) {
+	var specs []filterSpec
+	plaintext := true
+	pixels := true
+	for _, name := range names {
+		filter, err := lookupFilter(name)
 		if err != nil {
-			log.Fatalf("failed to process file '%s': %s\n", arg, err)
+			return filterSpec{}, err
+		}
+		if len(specs) > 0 && filter.size() != specs[0].size() {
+			return filterSpec{}, fmt.Errorf("pipeline filters %s and %s "+
+				"have different block sizes", specs[0].name, filter.name)
+		}
+		specs = append(specs, filter)
+		plaintext = plaintext && filter.plaintext
+		pixels = pixels && filter.pixels
+	}
+	if rgbMode && !pixels {
+		for _, spec := range specs {
+			if spec.pixels {
+				return filterSpec{}, fmt.Errorf("-rgb can't be used with a "+
+					"pipeline mixing %s with block filters", spec.name)
+			}
 		}
 	}
+	return filterSpec{
+		name:      strings.Join(names, "+"),
+		blockSize: specs[0].blockSize,
+		plaintext: plaintext,
+		pixels:    pixels,
+		newPosFilter: func() PosFilter {
+			var pipeline []PosFilter
+			for _, spec := range specs {
+				pipeline = append(pipeline, spec.instance())
+			}
+			return func(block []byte, seq int, pos BlockPos) error {
+				for _, f := range pipeline {
+					if err := f(block, seq, pos); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+		},
+	}, nil
 }
 
-func processFile(path string) error {
+func processFile(path string, filters []filterSpec) error {
 	f, err := os.Open(path)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	m, _, err := image.Decode(f)
+	if dryRun {
+		return dryRunFile(f, path, filters)
+	}
+	if skipExisting {
+		ok, err := upToDate(f, path, filters)
+		if err != nil {
+			return err
+		}
+		if ok {
+			progress.Printf("skipping file '%s': outputs are up to date\n",
+				path)
+			return nil
+		}
+	}
+
+	m, format, err := image.Decode(f)
+	if err != nil {
+		return decodeError(err)
+	}
+	if err := checkPixels(m.Bounds()); err != nil {
+		return err
+	}
+	if inPlace && format != "png" {
+		return fmt.Errorf("-inplace requires a PNG input, got %s", format)
+	}
+	gray, isGray16 := m.(*image.Gray16)
+	isGray16 = isGray16 && format == "tiff"
+	bpp := 4
+	if isGray16 {
+		bpp = 2
+	} else if rgbMode {
+		bpp = 3
+	}
+	err = checkImageSize(m.Bounds().Dx(), m.Bounds().Dy(), bpp, filters)
+	if err != nil {
+		return err
+	}
+	if format == "gif" {
+		// Process all frames of animated GIFs.
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		anim, err := gif.DecodeAll(f)
+		if err != nil {
+			return err
+		}
+		if len(anim.Image) > 1 {
+			return processGIF(path, anim, filters)
+		}
+	}
+	if isGray16 {
+		return processGray16(path, gray, filters)
+	}
+	m, err = scaleImage(m)
 	if err != nil {
 		return err
 	}
@@ -236,78 +1122,559 @@ func processFile(path string) error {
 	width := bounds.Max.X - bounds.Min.X
 	height := bounds.Max.Y - bounds.Min.Y
 
-	log.Printf("%d\u00d7%d\n", width, height)
+	progress.Printf("%d\u00d7%d\n", width, height)
+	if rgbMode && !opaque(m) {
+		progress.Printf("-rgb drops the alpha channel of the image\n")
+	}
+
+	// The scaling can make the image smaller than a block.
+	if err := checkImageSize(width, height, bpp, filters); err != nil {
+		return err
+	}
+	if delta {
+		m, err = deltaFrame(m)
+		if err != nil {
+			return err
+		}
+	}
+
+	var baseline *[256]int
+	if compareBaseline {
+		random, err := randomImage(width, height)
+		if err != nil {
+			return err
+		}
+		baseline = histogram(random)
+	}
+	var original *image.NRGBA
+	if computePSNR || verify {
+		original, err = processImage(m, filterSpec{f: FilterCopy})
+		if err != nil {
+			return err
+		}
+	}
+
+	if debugCBC {
+		chain, err := cbcChainImage(m)
+		if err != nil {
+			return err
+		}
+		err = save(chain, outputName(path, "AES-CBC-chain", "png"))
+		if err != nil {
+			return err
+		}
+	}
+
+	if cbcPadding {
+		ct, structure, err := cbcPadded(m)
+		if err != nil {
+			return err
+		}
+		err = save(ct, outputName(path, "AES-CBC-Padded", "png"))
+		if err != nil {
+			return err
+		}
+		err = save(structure,
+			outputName(path, "AES-CBC-Padded-structure", "png"))
+		if err != nil {
+			return err
+		}
+	}
+	if bitplanes {
+		err = save(bitplaneECB(m), outputName(path, "AES-ECB-Bitplanes", "png"))
+		if err != nil {
+			return err
+		}
+	}
+	if emitPlaintext {
+		err = writePlaintext(m, outputName(path, "plaintext", "bin"))
+		if err != nil {
+			return err
+		}
+	}
+	if keystreamOut {
+		err = writeKeystream(m, outputName(path, "AES-CTR-keystream", "bin"))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, filter := range filters {
+		if filter.expanding() {
+			progress.Printf("%-22s ciphertexts truncated to %d-byte blocks\n",
+				filter.name, filter.size())
+		}
+		name := outputName(path, filter.name, "png")
+		if inPlace {
+			name = path
+		}
+		if stream {
+			if err := streamImage(m, filter, name); err != nil {
+				return err
+			}
+			results = append(results, Result{
+				Input:  path,
+				Filter: filter.name,
+				Output: name,
+			})
+			continue
+		}
+		output, err := processImage(m, filter)
+		if err != nil {
+			return err
+		}
+		result := Result{
+			Input:  path,
+			Filter: filter.name,
+			Output: name,
+		}
+
+		if baseline != nil {
+			h := histogram(output)
+			e := entropy(h)
+			cs := chiSquare(h, baseline)
+			progress.Printf("%-22s entropy %.4f bits/byte, \u03c7\u00b2 %.1f\n",
+				filter.name, e, cs)
+			result.Entropy = &e
+			result.ChiSquare = &cs
+		}
+		if computePSNR {
+			v := psnr(original, output)
+			progress.Printf("%-22s PSNR %.2f dB\n", filter.name, v)
+			// JSON can't represent the +Inf of identical images.
+			if !math.IsInf(v, 0) {
+				result.PSNR = &v
+			}
+		}
+		if computeDupRatio {
+			v := dupRatio(output, filter.size())
+			progress.Printf("%-22s duplicate blocks %.2f%%\n",
+				filter.name, v*100)
+			result.DupRatio = &v
+		}
+		results = append(results, result)
+
+		if verify && filter.invertible() {
+			matched, total, err := verifyDecrypt(original, output, filter)
+			if err != nil {
+				return err
+			}
+			progress.Printf("%-22s verify: %d/%d blocks match\n",
+				filter.name, matched, total)
+		}
+		if wrongKey && filter.invertible() {
+			garbage, err := wrongKeyDecrypt(output, filter)
+			if err != nil {
+				return err
+			}
+			err = save(garbage, outputName(path, filter.name+"-wrongkey", "png"))
+			if err != nil {
+				return err
+			}
+		}
+		if jpegQuality > 0 {
+			lossy, err := jpegRoundTrip(output)
+			if err != nil {
+				return err
+			}
+			err = save(lossy, outputName(path, filter.name+"-jpeg", "png"))
+			if err != nil {
+				return err
+			}
+			if filter.invertible() {
+				decrypted, err := processImage(lossy, filter.decryptor())
+				if err != nil {
+					return err
+				}
+				err = save(decrypted,
+					outputName(path, filter.name+"-jpeg-decrypted", "png"))
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if avalancheMap {
+			diff, changed, err := avalanche(m, filter)
+			if err != nil {
+				return err
+			}
+			progress.Printf("%-22s avalanche: %d blocks changed\n",
+				filter.name, changed)
+			err = save(diff, outputName(path, filter.name+"-avalanche", "png"))
+			if err != nil {
+				return err
+			}
+		}
+		if highlightDups {
+			err = save(highlightDuplicates(output, filter.size()),
+				outputName(path, filter.name+"-dups", "png"))
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(watermarkCorner) > 0 {
+			watermark(output, watermarkText(filter.name))
+		}
+		err = saveLevel(output, result.Output, filter.pngLevel())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPixels tests that the image bounds contain pixels. Malformed
+// files can decode into images with zero width or height.
+func checkPixels(bounds image.Rectangle) error {
+	if bounds.Empty() {
+		return fmt.Errorf("image has no pixels: %d\u00d7%d",
+			bounds.Dx(), bounds.Dy())
+	}
+	return nil
+}
 
+// checkImageSize tests that a width×height image with bpp bytes per
+// pixel fills at least one cipher block of each filter.
+func checkImageSize(width, height, bpp int, filters []filterSpec) error {
 	for _, filter := range filters {
+		if width*height*bpp < filter.size() {
+			return fmt.Errorf("image %d\u00d7%d is smaller than one "+
+				"%d-byte cipher block of %s", width, height, filter.size(),
+				filter.name)
+		}
+	}
+	return nil
+}
+
+// processImage applies the filter to the image m and returns the
+// filtered image.
+func processImage(m image.Image, filter filterSpec) (*image.NRGBA, error) {
+	bounds := m.Bounds()
+	width := bounds.Max.X - bounds.Min.X
+	height := bounds.Max.Y - bounds.Min.Y
 
-		output := image.NewNRGBA(image.Rectangle{
+	output := &memorySink{
+		image: image.NewNRGBA(image.Rectangle{
 			Max: image.Point{
 				X: width,
 				Y: height,
 			},
-		})
+		}),
+	}
+	if err := filterImage(m, filter, output); err != nil {
+		return nil, err
+	}
+	return output.image, nil
+}
 
-		var zero [16]byte
-		var block [16]byte
-		var blockOfs int
-		var seq int
-
-		for y := 0; y < height; y++ {
-			for x := 0; x < width; x++ {
-				r, g, b, a := m.At(x, y).RGBA()
-				block[blockOfs+0] = byte(r >> 8)
-				block[blockOfs+1] = byte(g >> 8)
-				block[blockOfs+2] = byte(b >> 8)
-				block[blockOfs+3] = byte(a >> 8)
-				blockOfs += 4
-
-				if blockOfs >= len(block) {
-					if err := filter.f(&block, seq); err != nil {
-						return err
-					}
-					writeBlock(output, block[:], seq, x+1-blockOfs/4, y)
-					blockOfs = 0
-					seq++
-					block = zero
-				}
+// rowSink receives the filtered image row by row.
+type rowSink interface {
+	// Row returns the image where the pixels of the row y are
+	// written.
+	Row(y int) *image.NRGBA
+	// Flush is called when the row y is complete.
+	Flush(y int) error
+}
+
+type memorySink struct {
+	image *image.NRGBA
+}
+
+func (sink *memorySink) Row(y int) *image.NRGBA {
+	return sink.image
+}
+
+func (sink *memorySink) Flush(y int) error {
+	return nil
+}
+
+// filterImage applies the filter to the image m and writes the result
+// to the output sink. The output coordinates start from (0, 0)
+// regardless of the bounds of m.
+func filterImage(m image.Image, spec filterSpec, output rowSink) error {
+	bounds := m.Bounds()
+	width := bounds.Max.X - bounds.Min.X
+	height := bounds.Max.Y - bounds.Min.Y
+
+	if rgbMode {
+		return filterImageRGB(m, spec, output)
+	}
+
+	filter := spec.instance()
+	block := make([]byte, spec.size())
+	var seq int
+
+	return forEachBlock(width, height, len(block)/4, func(pos BlockPos) error {
+		if err := padBlock(block); err != nil {
+			return err
+		}
+		for i := 0; i < pos.N; i++ {
+			// Use non-premultiplied colors so that the encrypted
+			// images can be decrypted exactly.
+			x, y := pos.Pixel(i)
+			c := color.NRGBAModel.Convert(m.At(bounds.Min.X+x,
+				bounds.Min.Y+y)).(color.NRGBA)
+			packPixel(block[i*4:], [4]uint8{c.R, c.G, c.B, c.A})
+		}
+		if !skipTransparent || !transparent(block[:pos.N*4]) {
+			err := applyFilter(spec.name, filter, block, seq, pos)
+			if err != nil {
+				return err
 			}
-			if blockOfs > 0 {
-				if err := filter.f(&block, seq); err != nil {
-					return err
-				}
-				writeBlock(output, block[:blockOfs], seq, width-blockOfs/4, y)
-				blockOfs = 0
-				seq++
-				block = zero
+		}
+		writeBlock(output.Row(pos.Y), block[:pos.N*4], seq, pos)
+		seq++
+
+		if !columnOrder && pos.X+pos.N >= width {
+			return output.Flush(pos.Y)
+		}
+		return nil
+	})
+}
+
+// BlockPos describes the pixels of a cipher block. The block covers N
+// pixels starting from (X, Y), in the row or column direction
+// depending on the -order flag.
+type BlockPos struct {
+	X, Y, N int
+}
+
+// Pixel returns the coordinates of the block's ith pixel.
+func (pos BlockPos) Pixel(i int) (int, int) {
+	if columnOrder {
+		return pos.X, pos.Y + i
+	}
+	return pos.X + i, pos.Y
+}
+
+// runCtx is the context of the run. The block iteration is aborted
+// when the context is done, for example when the -timeout expires.
+var runCtx = context.Background()
+
+// forEachBlock calls fn for each cipher block of a width×height
+// image, each block covering the given number of pixels. The blocks
+// are laid out row by row, or column by column with the -order column
+// flag. The last block of a row (column) is partial if the width
+// (height) is not a multiple of the block's pixels.
+func forEachBlock(width, height, pixels int,
+	fn func(pos BlockPos) error) error {
+
+	lines, length := height, width
+	if columnOrder {
+		lines, length = width, height
+	}
+	for line := 0; line < lines; line++ {
+		if err := runCtx.Err(); err != nil {
+			return err
+		}
+		for ofs := 0; ofs < length; ofs += pixels {
+			n := pixels
+			if ofs+n > length {
+				n = length - ofs
+			}
+			pos := BlockPos{
+				X: ofs,
+				Y: line,
+				N: n,
+			}
+			if columnOrder {
+				pos.X, pos.Y = line, ofs
+			}
+			if err := fn(pos); err != nil {
+				return err
 			}
 		}
+	}
+	return nil
+}
 
-		err := save(output, fmt.Sprintf("%s-%s.png", path, filter.name))
-		if err != nil {
+// applyFilter applies the named filter to the block. The blocks after
+// the -max-blocks limit are passed through unchanged. The panics of
+// the filter are returned as errors.
+func applyFilter(name string, filter PosFilter, block []byte, seq int,
+	pos BlockPos) (err error) {
+
+	if maxBlocks > 0 && seq >= maxBlocks {
+		return nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("filter %s panicked at block %d: %v",
+				name, seq, r)
+		}
+	}()
+	return filter(block, seq, pos)
+}
+
+// transparent tests if all pixels of the block are fully
+// transparent.
+func transparent(block []byte) bool {
+	for i := compOffset(compA); i < len(block); i += 4 {
+		if block[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// supportedFormats lists the registered image decoders. The outputs
+// are written as PNG, as GIF for animated GIF inputs, and as TIFF for
+// 16-bit grayscale TIFF inputs. There is no WebP encoder.
+var supportedFormats = []string{"png", "jpeg", "gif", "webp", "tiff"}
+
+func decodeError(err error) error {
+	if errors.Is(err, image.ErrFormat) {
+		return fmt.Errorf("unsupported image format (supported formats: %s): %w",
+			strings.Join(supportedFormats, ", "), err)
+	}
+	return err
+}
+
+// dryRunFile prints the output files that would be created for the
+// input file path, without processing the image.
+func dryRunFile(f *os.File, path string, filters []filterSpec) error {
+	cfg, format, err := image.DecodeConfig(f)
+	if err != nil {
+		return decodeError(err)
+	}
+	// The outputs are uncompressed NRGBA images of the input size,
+	// 16-bit grayscale TIFFs for 16-bit grayscale TIFF inputs, or
+	// animated GIFs of paletted frames for animated GIF inputs.
+	size := cfg.Width * cfg.Height * 4
+	ext := "png"
+	var frames string
+	if format == "tiff" && cfg.ColorModel == color.Gray16Model {
+		size = cfg.Width * cfg.Height * 2
+		ext = "tiff"
+	}
+	if format == "gif" {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
 			return err
 		}
+		anim, err := gif.DecodeAll(f)
+		if err != nil {
+			return decodeError(err)
+		}
+		if len(anim.Image) > 1 {
+			size = 0
+			for _, frame := range anim.Image {
+				size += frame.Bounds().Dx() * frame.Bounds().Dy()
+			}
+			ext = "gif"
+			frames = fmt.Sprintf(", %d frames", len(anim.Image))
+		}
+	}
+
+	fmt.Printf("%s: %s %d\u00d7%d%s\n", path, format, cfg.Width, cfg.Height,
+		frames)
+	for _, filter := range filters {
+		fmt.Printf(" - %s (%d bytes uncompressed)\n",
+			outputName(path, filter.name, ext), size)
+	}
+	return nil
+}
+
+// defaultOutTemplate is the default -out-template. The names include
+// the key fingerprint for non-default keys.
+const defaultOutTemplate = "{{.Base}}-{{.Filter}}" +
+	"{{if .KeyFP}}-{{.KeyFP}}{{end}}.{{.Ext}}"
+
+var outTemplate = template.Must(template.New("output").Parse(
+	defaultOutTemplate))
+
+// outputFields are the fields of the -out-template.
+type outputFields struct {
+	Base   string
+	Filter string
+	Ext    string
+	KeyFP  string
+}
+
+// parseOutTemplate parses the -out-template and validates it by
+// executing it with example fields.
+func parseOutTemplate(val string) (*template.Template, error) {
+	tmpl, err := template.New("output").Parse(val)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output template: %s", err)
+	}
+	err = tmpl.Execute(io.Discard, outputFields{
+		Base:   "image.png",
+		Filter: "AES-ECB",
+		Ext:    "png",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid output template: %s", err)
+	}
+	return tmpl, nil
+}
+
+// outputName creates the output file name for the filter from the
+// -out-template.
+func outputName(path, filter, ext string) string {
+	var b strings.Builder
+	err := outTemplate.Execute(&b, outputFields{
+		Base:   path,
+		Filter: filter,
+		Ext:    ext,
+		KeyFP:  keyFingerprint,
+	})
+	if err != nil {
+		// The template was validated in parseOutTemplate.
+		log.Fatalf("output template: %s\n", err)
+	}
+	return b.String()
+}
+
+// padBlock fills the block with the padding value. The bytes that are
+// not overwritten by pixel data remain as padding in the partial
+// blocks at the end of rows.
+func padBlock(block []byte) error {
+	if padRandom {
+		_, err := io.ReadFull(RandReader, block)
+		return err
+	}
+	for i := 0; i < len(block); i++ {
+		block[i] = padByte
 	}
 	return nil
 }
 
-func writeBlock(image *image.NRGBA, block []byte, seq, x, y int) {
-	for i := 0; i+4 <= len(block); i += 4 {
+// writeBlock writes the block's pixels to the image at the block
+// position pos. The pixels outside the image bounds are ignored.
+func writeBlock(image *image.NRGBA, block []byte, seq int, pos BlockPos) {
+	bounds := image.Bounds()
+	for i := 0; i*4+4 <= len(block); i++ {
+		x, y := pos.Pixel(i)
+		if x < bounds.Min.X || x >= bounds.Max.X ||
+			y < bounds.Min.Y || y >= bounds.Max.Y {
+			continue
+		}
+		c := unpackPixel(block[i*4:])
 		image.Set(x, y, color.NRGBA{
-			R: block[i+0],
-			G: block[i+1],
-			B: block[i+2],
-			A: block[i+3],
+			R: c[compR],
+			G: c[compG],
+			B: c[compB],
+			A: c[compA],
 		})
-		x++
 	}
 }
 
 func save(image *image.NRGBA, name string) error {
+	return saveLevel(image, name, pngLevel)
+}
+
+func saveLevel(image *image.NRGBA, name string,
+	level png.CompressionLevel) error {
+
 	out, err := os.Create(name)
 	if err != nil {
 		return err
 	}
 	defer out.Close()
 
-	return png.Encode(out, image)
+	enc := &png.Encoder{
+		CompressionLevel: level,
+	}
+	return enc.Encode(out, image)
 }
@@ -0,0 +1,709 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"errors"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	progress.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func testBlock(n int) []byte {
+	block := make([]byte, n)
+	for i := 0; i < len(block); i++ {
+		block[i] = byte(0x10 + i)
+	}
+	return block
+}
+
+func TestColorFilters(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		keep   int
+	}{
+		{"red", FilterRed, compR},
+		{"green", FilterGreen, compG},
+		{"blue", FilterBlue, compB},
+	}
+	for _, test := range tests {
+		for _, n := range []int{16, 15, 13, 6} {
+			block := testBlock(n)
+			if err := test.filter(block, 0); err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			for i, b := range block {
+				comp := pixelOrder[i%4]
+				expected := testBlock(n)[i]
+				if comp != test.keep && comp != compA {
+					expected = 0
+				}
+				if b != expected {
+					t.Errorf("%s: %d-byte block: byte %d: got %02x, "+
+						"expected %02x", test.name, n, i, b, expected)
+				}
+			}
+		}
+	}
+}
+
+func TestColorFiltersRGB(t *testing.T) {
+	rgbMode = true
+	defer func() {
+		rgbMode = false
+	}()
+
+	// 7 pixels per row are 21 bytes, so the pixels are not aligned
+	// to the RGB blocks.
+	m := image.NewNRGBA(image.Rect(0, 0, 7, 3))
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 7; x++ {
+			m.SetNRGBA(x, y, color.NRGBA{0x11, 0x22, 0x33, 0xff})
+		}
+	}
+	pipeline, err := newPipeline([]string{"red", "blue"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newPipeline([]string{"red", "AES-ECB"}); err == nil {
+		t.Errorf("-rgb accepted a pipeline mixing red and AES-ECB")
+	}
+	red, err := lookupFilter("red")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		spec     filterSpec
+		expected color.NRGBA
+	}{
+		{red, color.NRGBA{0x11, 0, 0, 0xff}},
+		{pipeline, color.NRGBA{0, 0, 0, 0xff}},
+	}
+	for _, test := range tests {
+		out, err := processImage(m, test.spec)
+		if err != nil {
+			t.Fatalf("%s: %v", test.spec.name, err)
+		}
+		for y := 0; y < 3; y++ {
+			for x := 0; x < 7; x++ {
+				if c := out.NRGBAAt(x, y); c != test.expected {
+					t.Errorf("%s: pixel %d,%d: got %v, expected %v",
+						test.spec.name, x, y, c, test.expected)
+				}
+			}
+		}
+	}
+
+	legend, err := legendImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legend.Bounds().Empty() {
+		t.Errorf("empty legend image")
+	}
+}
+
+func TestGCMNonce(t *testing.T) {
+	nonce := seqNonce(0x0102030405060708, 12)
+	expected := []byte{1, 2, 3, 4, 5, 6, 7, 8, 0, 0, 0, 0}
+	if !bytes.Equal(nonce, expected) {
+		t.Errorf("nonce: got %x, expected %x", nonce, expected)
+	}
+
+	block := testBlock(16)
+	ct, err := AESGCM(append([]byte(nil), block...), 0x0102030405060708)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ct, cipherGCM.Seal(nil, expected, block, nil)) {
+		t.Errorf("AES-GCM ciphertext does not use the seq nonce")
+	}
+
+	ct0, err := AESGCM(append([]byte(nil), block...), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct1, err := AESGCM(append([]byte(nil), block...), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(ct0, ct1) {
+		t.Errorf("equal blocks with different seq encrypt to %x", ct0)
+	}
+}
+
+func TestFilterPanic(t *testing.T) {
+	spec := filterSpec{
+		name: "Panic",
+		f: func(block []byte, seq int) error {
+			if seq == 3 {
+				panic("invalid block size")
+			}
+			return nil
+		},
+	}
+	m := image.NewNRGBA(image.Rect(0, 0, 16, 2))
+	_, err := processImage(m, spec)
+	if err == nil {
+		t.Fatal("panicking filter did not return an error")
+	}
+	expected := "filter Panic panicked at block 3: invalid block size"
+	if !strings.Contains(err.Error(), expected) {
+		t.Errorf("got error %q, expected %q", err, expected)
+	}
+}
+
+func TestTinyImages(t *testing.T) {
+	aesECB, err := lookupFilter("AES-ECB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	threefish, err := lookupFilter("Threefish-512-ECB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		width, height, bpp int
+		filter             filterSpec
+		ok                 bool
+	}{
+		{1, 1, 4, aesECB, false},
+		{2, 2, 4, aesECB, true},
+		{2, 2, 3, aesECB, false},
+		{3, 2, 3, aesECB, true},
+		{2, 2, 2, aesECB, false},
+		{4, 2, 2, aesECB, true},
+		{2, 2, 4, threefish, false},
+		{4, 4, 4, threefish, true},
+		{16, 1, 4, threefish, true},
+	}
+	for _, test := range tests {
+		err := checkImageSize(test.width, test.height, test.bpp,
+			[]filterSpec{test.filter})
+		if (err == nil) != test.ok {
+			t.Errorf("%d\u00d7%d, %d bytes/pixel, %s: got %v",
+				test.width, test.height, test.bpp, test.filter.name, err)
+		}
+	}
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "tiny.png")
+	if err := save(image.NewNRGBA(image.Rect(0, 0, 1, 1)), name); err != nil {
+		t.Fatal(err)
+	}
+	err = processFile(name, []filterSpec{aesECB})
+	if err == nil || !strings.Contains(err.Error(), "smaller than one") {
+		t.Errorf("1\u00d71 PNG: got %v", err)
+	}
+
+	anim := &gif.GIF{
+		Image: []*image.Paletted{
+			image.NewPaletted(image.Rect(0, 0, 1, 1), palette.Plan9),
+			image.NewPaletted(image.Rect(0, 0, 1, 1), palette.Plan9),
+		},
+		Delay: []int{10, 10},
+	}
+	name = filepath.Join(dir, "tiny.gif")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gif.EncodeAll(f, anim)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = processFile(name, []filterSpec{aesECB})
+	if err == nil || !strings.Contains(err.Error(), "smaller than one") {
+		t.Errorf("1\u00d71 animated GIF: got %v", err)
+	}
+}
+
+func TestWideImage(t *testing.T) {
+	const width = 100003
+
+	m := image.NewNRGBA(image.Rect(0, 0, width, 1))
+	for i := range m.Pix {
+		m.Pix[i] = byte(i * 7)
+	}
+	output, err := processImage(m, filterSpec{f: FilterCopy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output.Pix, m.Pix) {
+		t.Errorf("copied %d\u00d71 image differs from the input", width)
+	}
+
+	// The block extends past the right edge of the image.
+	block := testBlock(16)
+	writeBlock(output, block, 0, BlockPos{X: width - 2, Y: 0, N: 4})
+	if !bytes.Equal(output.Pix[len(output.Pix)-8:], block[:8]) {
+		t.Errorf("the in-bounds pixels of the block were not written")
+	}
+	writeBlock(output, block, 0, BlockPos{X: 0, Y: 1, N: 4})
+}
+
+func TestSubImage(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 20, 10))
+	for i := range m.Pix {
+		m.Pix[i] = byte(i * 13)
+	}
+	rect := image.Rect(4, 3, 16, 9)
+	sub := m.SubImage(rect).(*image.NRGBA)
+
+	// The same pixels with a zero origin.
+	flat := image.NewNRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			flat.SetNRGBA(x, y, m.NRGBAAt(rect.Min.X+x, rect.Min.Y+y))
+		}
+	}
+
+	for _, name := range []string{"AES-ECB", "AES-CBC"} {
+		filter, err := lookupFilter(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := processImage(sub, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := processImage(flat, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Rect != b.Rect || !bytes.Equal(a.Pix, b.Pix) {
+			t.Errorf("%s: sub-image output differs from zero-origin output",
+				name)
+		}
+	}
+	copied, err := processImage(sub, filterSpec{f: FilterCopy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(copied.Pix, flat.Pix) {
+		t.Errorf("copied sub-image differs from its pixels")
+	}
+}
+
+func TestEmptyImage(t *testing.T) {
+	for _, rect := range []image.Rectangle{
+		image.Rect(0, 0, 0, 0),
+		image.Rect(0, 0, 0, 5),
+		image.Rect(0, 0, 5, 0),
+		image.Rect(3, 3, 3, 3),
+	} {
+		m := image.NewNRGBA(rect)
+		err := checkPixels(m.Bounds())
+		if err == nil || !strings.Contains(err.Error(), "no pixels") {
+			t.Errorf("%v: got %v", rect, err)
+		}
+	}
+	if err := checkPixels(image.Rect(0, 0, 1, 1)); err != nil {
+		t.Errorf("1\u00d71: %v", err)
+	}
+
+	output, err := processImage(image.NewNRGBA(image.Rectangle{}),
+		filterSpec{f: AESECB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !output.Rect.Empty() {
+		t.Errorf("0\u00d70 image output has bounds %v", output.Rect)
+	}
+}
+
+// fixedReader returns the bytes 0, 1, 2, ... as its random data.
+type fixedReader struct {
+	next byte
+}
+
+func (r *fixedReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = r.next
+		r.next++
+	}
+	return len(p), nil
+}
+
+func TestRandReader(t *testing.T) {
+	defer func(r io.Reader) {
+		RandReader = r
+	}(RandReader)
+
+	for _, test := range []struct {
+		name string
+		seal Sealer
+	}{
+		{"AES-KWP-RandomIV", AESKWPRandomIV},
+		{"AES-KWP-RandomFixedIVs", AESKWPRandomFixedIVs},
+	} {
+		var outputs [][]byte
+		for i := 0; i < 2; i++ {
+			RandReader = &fixedReader{}
+			var output []byte
+			for seq := 0; seq < 4; seq++ {
+				ct, err := test.seal(testBlock(16), seq)
+				if err != nil {
+					t.Fatal(err)
+				}
+				output = append(output, ct...)
+			}
+			outputs = append(outputs, output)
+		}
+		if !bytes.Equal(outputs[0], outputs[1]) {
+			t.Errorf("%s: outputs differ with the same random data",
+				test.name)
+		}
+	}
+
+	// The IV is the first 16 bytes of the random data.
+	RandReader = &fixedReader{}
+	ct, err := AESKWPRandomIV(testBlock(16), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv := make([]byte, 16)
+	(&fixedReader{}).Read(iv)
+	expected, err := cipherAESKWP.Wrap(append(iv, testBlock(16)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ct, expected[16:]) {
+		t.Errorf("AES-KWP-RandomIV: got %x, expected %x", ct, expected[16:])
+	}
+}
+
+func TestIVModulus(t *testing.T) {
+	defer func(mod int) {
+		ivModulus = mod
+	}(ivModulus)
+
+	m := genImage(penguin, 128, 128, 64)
+	filter, err := lookupFilter("AES-KWP-FixedIVs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := 2.0
+	for _, mod := range []int{1, 16, 256} {
+		ivModulus = mod
+		output, err := processImage(m, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := dupRatio(output, filter.size())
+		if v >= prev {
+			t.Errorf("-iv-mod %d: duplicate blocks %.2f%%, expected less "+
+				"than %.2f%%", mod, v*100, prev*100)
+		}
+		prev = v
+	}
+}
+
+func TestLookupFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"ecb", "AES-ECB"},
+		{"ECB", "AES-ECB"},
+		{"aes-ecb", "AES-ECB"},
+		{"Gcm", "AES-GCM"},
+		{"siv", "AES-GCM-SIV"},
+		{"gcm-siv", "AES-GCM-SIV"},
+		{"threefish", "Threefish-512-ECB"},
+		// The aliases name the base filters of their variants.
+		{"ctr", "AES-CTR"},
+		{"kwp", "AES-KWP"},
+		{"cbc", "AES-CBC"},
+		{"cbc-hmac", "AES-CBC-HMAC"},
+	}
+	for _, test := range tests {
+		filter, err := lookupFilter(test.name)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if filter.name != test.expected {
+			t.Errorf("%s: got %s, expected %s", test.name, filter.name,
+				test.expected)
+		}
+	}
+	for _, name := range []string{"", "aes", "ecb2", "AES-ECB ", "gcm,ctr"} {
+		if _, err := lookupFilter(name); err == nil {
+			t.Errorf("unknown filter %q was found", name)
+		}
+	}
+
+	// Each alias must resolve to a registered filter, and it must not
+	// shadow the name of another filter.
+	for alias, target := range filterAliases {
+		if _, err := lookupFilter(target); err != nil {
+			t.Errorf("alias %s: %v", alias, err)
+		}
+		for _, filter := range filters {
+			if strings.EqualFold(filter.name, alias) && filter.name != target {
+				t.Errorf("alias %s of %s is ambiguous with filter %s",
+					alias, target, filter.name)
+			}
+		}
+	}
+}
+
+func TestWebP(t *testing.T) {
+	data, err := os.ReadFile("testdata/flat.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "webp" {
+		t.Errorf("format %s, expected webp", format)
+	}
+	if m.Bounds() != image.Rect(0, 0, 8, 2) {
+		t.Fatalf("bounds %v, expected 8×2", m.Bounds())
+	}
+	expected := color.NRGBA{R: 0xe0, G: 0x40, B: 0x30, A: 0xff}
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 8; x++ {
+			c := color.NRGBAModel.Convert(m.At(x, y))
+			if c != expected {
+				t.Errorf("pixel (%d,%d): got %v, expected %v", x, y, c,
+					expected)
+			}
+		}
+	}
+
+	// The outputs of WebP inputs are PNG images.
+	name := filepath.Join(t.TempDir(), "flat.webp")
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	filter, err := lookupFilter("AES-ECB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := processFile(name, []filterSpec{filter}); err != nil {
+		t.Fatal(err)
+	}
+	output, err := loadNRGBA(outputName(name, filter.name, "png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The 4 blocks of the flat image are equal.
+	if v := dupRatio(output, filter.size()); v != 0.75 {
+		t.Errorf("AES-ECB duplicate blocks %.2f%%, expected 75%%", v*100)
+	}
+}
+
+func TestKeyEnv(t *testing.T) {
+	defer setupKey(defaultKey())
+
+	envKey := "2b7e151628aed2a6abf7158809cf4f3cef4359d8d580aa4f7f036d6f04fc6a94"
+	flagKey := "ff7e151628aed2a6abf7158809cf4f3cef4359d8d580aa4f7f036d6f04fc6a94"
+	t.Setenv(keyEnv, envKey)
+
+	keys, err := parseKeys("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || !bytes.Equal(keys[0], decodeHex(t, envKey)) {
+		t.Fatalf("got keys %x, expected the %s key", keys, keyEnv)
+	}
+	if err := setupKey(keys[0]); err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(decodeHex(t, envKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := testBlock(16)
+	b := testBlock(16)
+	AESECB(a, 0)
+	block.Encrypt(b, b)
+	if !bytes.Equal(a, b) {
+		t.Errorf("the cipher is not built from the %s key", keyEnv)
+	}
+
+	// The flags take precedence over the environment.
+	keys, err = parseKeys(flagKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || !bytes.Equal(keys[0], decodeHex(t, flagKey)) {
+		t.Errorf("-key: got keys %x, expected %s", keys, flagKey)
+	}
+	keys, err = parseKeys("", flagKey+","+envKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || !bytes.Equal(keys[0], decodeHex(t, flagKey)) {
+		t.Errorf("-keys: got keys %x", keys)
+	}
+	if _, err := parseKeys(flagKey, envKey); err == nil {
+		t.Errorf("-key and -keys were accepted together")
+	}
+
+	t.Setenv(keyEnv, "not hex")
+	if _, err := parseKeys("", ""); err == nil {
+		t.Errorf("invalid %s key was accepted", keyEnv)
+	}
+
+	t.Setenv(keyEnv, "")
+	keys, err = parseKeys("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || !bytes.Equal(keys[0], defaultKey()) {
+		t.Errorf("got keys %x, expected the default key", keys)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	defer func(ctx context.Context) {
+		runCtx = ctx
+	}(runCtx)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	runCtx = ctx
+
+	filter, err := lookupFilter("AES-CBC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := genImage(penguin, 2048, 2048, 64)
+	if _, err := processImage(m, filter); !errors.Is(err,
+		context.DeadlineExceeded) {
+		t.Errorf("image: got %v, expected %v", err, context.DeadlineExceeded)
+	}
+
+	anim := &gif.GIF{
+		Image: []*image.Paletted{
+			image.NewPaletted(image.Rect(0, 0, 64, 64), palette.Plan9),
+			image.NewPaletted(image.Rect(0, 0, 64, 64), palette.Plan9),
+		},
+		Delay: []int{10, 10},
+	}
+	name := filepath.Join(t.TempDir(), "anim.gif")
+	err = processGIF(name, anim, []filterSpec{filter})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("animated GIF: got %v, expected %v", err,
+			context.DeadlineExceeded)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	defer func(r io.Reader) {
+		RandReader = r
+	}(RandReader)
+
+	// The expanding filters keep only the block length of their
+	// ciphertexts.
+	for _, spec := range filters {
+		if !spec.expanding() {
+			continue
+		}
+		block := testBlock(spec.size())
+		RandReader = &fixedReader{}
+		ct, err := spec.seal(append([]byte(nil), block...), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ct) <= len(block) {
+			t.Errorf("%s: sealed %d bytes, expected more than %d",
+				spec.name, len(ct), len(block))
+		}
+		truncated := append([]byte(nil), block...)
+		RandReader = &fixedReader{}
+		if err := truncate(spec.seal)(truncated, 0); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(truncated, ct[:len(block)]) {
+			t.Errorf("%s: truncated %x, expected %x", spec.name, truncated,
+				ct[:len(block)])
+		}
+	}
+
+	short := truncate(func(block []byte, seq int) ([]byte, error) {
+		return block[:len(block)-1], nil
+	})
+	if err := short(testBlock(16), 0); err == nil {
+		t.Errorf("short ciphertext was accepted")
+	}
+}
+
+func TestDryRunGIF(t *testing.T) {
+	anim := &gif.GIF{
+		Image: []*image.Paletted{
+			image.NewPaletted(image.Rect(0, 0, 8, 4), palette.Plan9),
+			image.NewPaletted(image.Rect(0, 0, 8, 4), palette.Plan9),
+			image.NewPaletted(image.Rect(0, 0, 8, 4), palette.Plan9),
+		},
+		Delay: []int{10, 10, 10},
+	}
+	aesECB, err := lookupFilter("AES-ECB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(t.TempDir(), "anim.gif")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := gif.EncodeAll(f, anim); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	err = dryRunFile(f, name, []filterSpec{aesECB})
+	os.Stdout = stdout
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := outputName(name, "AES-ECB", "gif") + " (96 bytes uncompressed)"
+	if !strings.Contains(string(out), expected) {
+		t.Errorf("got %q, expected %q", out, expected)
+	}
+	if !strings.Contains(string(out), "3 frames") {
+		t.Errorf("got %q, expected 3 frames", out)
+	}
+}
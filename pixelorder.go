@@ -0,0 +1,56 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+// The color components of the pixels.
+const (
+	compR = iota
+	compG
+	compB
+	compA
+)
+
+// pixelOrder defines the byte order of the pixels in the cipher
+// blocks, set with the -pixel-order flag. It holds the color
+// component of each of the 4 pixel bytes.
+var pixelOrder = pixelOrders["rgba"]
+
+var pixelOrders = map[string][4]int{
+	"rgba": {compR, compG, compB, compA},
+	"bgra": {compB, compG, compR, compA},
+	"argb": {compA, compR, compG, compB},
+	"abgr": {compA, compB, compG, compR},
+}
+
+// compOffset returns the offset of the color component in the pixel
+// bytes.
+func compOffset(comp int) int {
+	for i, c := range pixelOrder {
+		if c == comp {
+			return i
+		}
+	}
+	panic("invalid color component")
+}
+
+// packPixel packs the color components into the pixel bytes dst in
+// the -pixel-order.
+func packPixel(dst []byte, comps [4]uint8) {
+	for i, c := range pixelOrder {
+		dst[i] = comps[c]
+	}
+}
+
+// unpackPixel returns the color components of the pixel bytes src in
+// the -pixel-order.
+func unpackPixel(src []byte) [4]uint8 {
+	var comps [4]uint8
+	for i, c := range pixelOrder {
+		comps[c] = src[i]
+	}
+	return comps
+}
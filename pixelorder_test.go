@@ -0,0 +1,69 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestPixelOrder(t *testing.T) {
+	defer func(order [4]int) {
+		pixelOrder = order
+	}(pixelOrder)
+
+	m := image.NewNRGBA(image.Rect(0, 0, 12, 3))
+	for i := range m.Pix {
+		m.Pix[i] = byte(i*29 + 1)
+	}
+	filter, err := lookupFilter("AES-ECB")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outputs := make(map[string][]byte)
+	for name, order := range pixelOrders {
+		pixelOrder = order
+
+		comps := [4]uint8{0x11, 0x22, 0x33, 0x44}
+		var buf [4]byte
+		packPixel(buf[:], comps)
+		for i, c := range order {
+			if buf[i] != comps[c] {
+				t.Errorf("%s: byte %d: got %02x, expected %02x", name, i,
+					buf[i], comps[c])
+			}
+		}
+		if unpackPixel(buf[:]) != comps {
+			t.Errorf("%s: unpacked %x, expected %x", name,
+				unpackPixel(buf[:]), comps)
+		}
+
+		ct, err := processImage(m, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pt, err := processImage(ct, filter.decryptor())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(pt.Pix, m.Pix) {
+			t.Errorf("%s: decrypted image differs from the input", name)
+		}
+		outputs[name] = ct.Pix
+	}
+
+	// The byte order changes the ciphertext.
+	for a, ctA := range outputs {
+		for b, ctB := range outputs {
+			if a != b && bytes.Equal(ctA, ctB) {
+				t.Errorf("%s and %s give the same ciphertext", a, b)
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"io"
+	"math"
+)
+
+// Result holds the metrics of one filter output.
+type Result struct {
+	Input     string   `json:"input"`
+	Filter    string   `json:"filter"`
+	Output    string   `json:"output"`
+	PSNR      *float64 `json:"psnr,omitempty"`
+	Entropy   *float64 `json:"entropy,omitempty"`
+	ChiSquare *float64 `json:"chiSquare,omitempty"`
+	DupRatio  *float64 `json:"dupRatio,omitempty"`
+}
+
+var results []Result
+
+// writeReport writes the results as a JSON array into w. An empty
+// run writes an empty array.
+func writeReport(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if results == nil {
+		return enc.Encode([]Result{})
+	}
+	return enc.Encode(results)
+}
+
+// psnr computes the peak signal-to-noise ratio of the images a and b
+// in decibels. The images must have the same size. The function
+// returns +Inf for identical images.
+func psnr(a, b *image.NRGBA) float64 {
+	var sum float64
+	var count int
+
+	width := a.Rect.Dx()
+	for y := 0; y < a.Rect.Dy(); y++ {
+		ao := a.PixOffset(a.Rect.Min.X, a.Rect.Min.Y+y)
+		bo := b.PixOffset(b.Rect.Min.X, b.Rect.Min.Y+y)
+		for i := 0; i < width*4; i++ {
+			diff := float64(a.Pix[ao+i]) - float64(b.Pix[bo+i])
+			sum += diff * diff
+			count++
+		}
+	}
+	if sum == 0 {
+		return math.Inf(1)
+	}
+	mse := sum / float64(count)
+
+	return 10 * math.Log10(255*255/mse)
+}
@@ -0,0 +1,85 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color/palette"
+	"image/gif"
+	"path/filepath"
+	"testing"
+)
+
+func TestReport(t *testing.T) {
+	defer func() {
+		results = nil
+	}()
+
+	results = nil
+	var buf bytes.Buffer
+	if err := writeReport(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "[]\n" {
+		t.Errorf("empty run: got %q, expected %q", buf.String(), "[]\n")
+	}
+
+	aesECB, err := lookupFilter("AES-ECB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	anim := &gif.GIF{
+		Image: []*image.Paletted{
+			image.NewPaletted(image.Rect(0, 0, 8, 4), palette.Plan9),
+			image.NewPaletted(image.Rect(0, 0, 8, 4), palette.Plan9),
+		},
+		Delay: []int{10, 10},
+	}
+	animName := filepath.Join(dir, "anim.gif")
+	if err := processGIF(animName, anim, []filterSpec{aesECB}); err != nil {
+		t.Fatal(err)
+	}
+	grayName := filepath.Join(dir, "gray.tiff")
+	err = processGray16(grayName, gray16TestImage(8, 4),
+		[]filterSpec{aesECB})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	if err := writeReport(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var report []Result
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	expected := []Result{
+		{
+			Input:  animName,
+			Filter: "AES-ECB",
+			Output: outputName(animName, "AES-ECB", "gif"),
+		},
+		{
+			Input:  grayName,
+			Filter: "AES-ECB",
+			Output: outputName(grayName, "AES-ECB", "tiff"),
+		},
+	}
+	if len(report) != len(expected) {
+		t.Fatalf("got %d results, expected %d", len(report), len(expected))
+	}
+	for i, r := range report {
+		if r != expected[i] {
+			t.Errorf("result %d: got %+v, expected %+v", i, r, expected[i])
+		}
+	}
+}
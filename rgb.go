@@ -0,0 +1,127 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// rgbMode packs only the RGB components of the pixels into the cipher
+// blocks, set with the -rgb flag.
+var rgbMode bool
+
+// opaque tests if the image m has no transparent pixels.
+func opaque(m image.Image) bool {
+	if o, ok := m.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+// rgbBlockPos returns the position of the n-byte RGB block starting
+// from the byte offset ofs of the line. The block covers the pixels
+// that it has bytes of.
+func rgbBlockPos(line, ofs, n int) BlockPos {
+	pos := BlockPos{
+		X: ofs / 3,
+		Y: line,
+		N: (ofs+n+2)/3 - ofs/3,
+	}
+	if columnOrder {
+		pos.X, pos.Y = line, pos.X
+	}
+	return pos
+}
+
+// filterImageRGB applies the filter to the RGB components of the
+// image m and writes the opaque result to the output sink. Each row
+// (column) is packed into 3 bytes per pixel and split into cipher
+// blocks, so the blocks are not aligned to the pixels: a 16-byte
+// block covers 5⅓ pixels. The last block of a row (column) is
+// partial. The pixel filters are applied to each pixel instead.
+func filterImageRGB(m image.Image, spec filterSpec, output rowSink) error {
+	bounds := m.Bounds()
+	width := bounds.Max.X - bounds.Min.X
+	height := bounds.Max.Y - bounds.Min.Y
+
+	lines, length := height, width
+	if columnOrder {
+		lines, length = width, height
+	}
+	pixel := func(line, i int) (int, int) {
+		if columnOrder {
+			return line, i
+		}
+		return i, line
+	}
+
+	filter := spec.instance()
+	block := make([]byte, spec.size())
+	buf := make([]byte, length*3)
+	var seq int
+
+	for line := 0; line < lines; line++ {
+		if err := runCtx.Err(); err != nil {
+			return err
+		}
+		for i := 0; i < length; i++ {
+			x, y := pixel(line, i)
+			c := color.NRGBAModel.Convert(m.At(bounds.Min.X+x,
+				bounds.Min.Y+y)).(color.NRGBA)
+			buf[i*3+0] = c.R
+			buf[i*3+1] = c.G
+			buf[i*3+2] = c.B
+		}
+		if spec.pixels {
+			// Apply the pixel filters to the opaque RGBA pixels.
+			for i := 0; i < length; i++ {
+				var px [4]byte
+				packPixel(px[:], [4]uint8{buf[i*3+0], buf[i*3+1],
+					buf[i*3+2], 0xff})
+				pos := rgbBlockPos(line, i*3, 3)
+				err := applyFilter(spec.name, filter, px[:], seq, pos)
+				if err != nil {
+					return err
+				}
+				c := unpackPixel(px[:])
+				buf[i*3+0] = c[compR]
+				buf[i*3+1] = c[compG]
+				buf[i*3+2] = c[compB]
+				seq++
+			}
+		}
+		for ofs := 0; !spec.pixels && ofs < len(buf); ofs += len(block) {
+			if err := padBlock(block); err != nil {
+				return err
+			}
+			n := copy(block, buf[ofs:])
+			pos := rgbBlockPos(line, ofs, n)
+			err := applyFilter(spec.name, filter, block, seq, pos)
+			if err != nil {
+				return err
+			}
+			copy(buf[ofs:ofs+n], block)
+			seq++
+		}
+		for i := 0; i < length; i++ {
+			x, y := pixel(line, i)
+			output.Row(y).SetNRGBA(x, y, color.NRGBA{
+				R: buf[i*3+0],
+				G: buf[i*3+1],
+				B: buf[i*3+2],
+				A: 0xff,
+			})
+		}
+		if !columnOrder {
+			if err := output.Flush(line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
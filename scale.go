@@ -0,0 +1,47 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"fmt"
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// scaleFactor is the -scale factor of the input images. The images
+// are not scaled if the factor is 1.
+var scaleFactor = 1.0
+
+// scaler is the -interp interpolation of the scaling.
+var scaler draw.Scaler = draw.NearestNeighbor
+
+// interpolators map the -interp names to the scalers. The nearest
+// neighbor interpolation keeps the hard block edges of the ECB
+// patterns while bilinear interpolation smooths them.
+var interpolators = map[string]draw.Scaler{
+	"nearest":  draw.NearestNeighbor,
+	"bilinear": draw.BiLinear,
+}
+
+// scaleImage scales the image m with the -scale factor and the -interp
+// interpolation.
+func scaleImage(m image.Image) (image.Image, error) {
+	if scaleFactor == 1 {
+		return m, nil
+	}
+	bounds := m.Bounds()
+	width := int(float64(bounds.Dx()) * scaleFactor)
+	height := int(float64(bounds.Dy()) * scaleFactor)
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("scaled image has no pixels: %d×%d",
+			width, height)
+	}
+	result := image.NewNRGBA(image.Rect(0, 0, width, height))
+	scaler.Scale(result, result.Rect, m, bounds, draw.Src, nil)
+	return result, nil
+}
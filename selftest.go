@@ -0,0 +1,102 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+)
+
+// selfTests are the known-answer tests of the ciphers.
+var selfTests = []struct {
+	name     string
+	key      string
+	input    string
+	expected string
+	run      func(input []byte) ([]byte, error)
+}{
+	{
+		// FIPS-197 Appendix C.3.
+		name:     "AES-256",
+		key:      "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+		input:    "00112233445566778899aabbccddeeff",
+		expected: "8ea2b7ca516745bfeafc49904b496089",
+		run: func(input []byte) ([]byte, error) {
+			err := AESECB(input, 0)
+			return input, err
+		},
+	},
+	{
+		// The GCM specification test case 14. The nonce of block 0 is
+		// all zeros.
+		name:  "AES-256-GCM",
+		key:   "0000000000000000000000000000000000000000000000000000000000000000",
+		input: "00000000000000000000000000000000",
+		expected: "cea7403d4d606b6e074ec5d3baf39d18" +
+			"d0d1c8a799996bf0265b98b5d48ab919",
+		run: func(input []byte) ([]byte, error) {
+			return AESGCM(input, 0)
+		},
+	},
+	{
+		// RFC 8452 Appendix C.2.
+		name:  "AES-256-GCM-SIV",
+		key:   "0100000000000000000000000000000000000000000000000000000000000000",
+		input: "0100000000000000",
+		expected: "c2ef328e5c71c83b" +
+			"843122130f7364b761e0b97427e3df28",
+		run: func(input []byte) ([]byte, error) {
+			nonce, _ := hex.DecodeString("030000000000000000000000")
+			return cipherGCMSIV.Seal(nil, nonce, input, nil), nil
+		},
+	},
+	{
+		// NIST SP 800-38G FF1 sample 7, with the digits as numerals.
+		name:     "FF1-AES-256",
+		key:      "2b7e151628aed2a6abf7158809cf4f3cef4359d8d580aa4f7f036d6f04fc6a94",
+		input:    "00010203040506070809",
+		expected: "06060507060607000009",
+		run: func(input []byte) ([]byte, error) {
+			return newFF1(cipherAES256, 10).Encrypt(input, nil), nil
+		},
+	},
+}
+
+// selfTest runs the known-answer tests and reports their results. It
+// returns an error if any of the tests fail. The current key is
+// restored after the tests.
+func selfTest() error {
+	key := currentKey
+	defer setupKey(key)
+
+	var failed int
+	for _, test := range selfTests {
+		k, _ := hex.DecodeString(test.key)
+		input, _ := hex.DecodeString(test.input)
+		expected, _ := hex.DecodeString(test.expected)
+
+		if err := setupKey(k); err != nil {
+			return err
+		}
+		result, err := test.run(input)
+		if err != nil {
+			return fmt.Errorf("%s: %s", test.name, err)
+		}
+		if bytes.Equal(result, expected) {
+			fmt.Printf("%-22s ok\n", test.name)
+		} else {
+			fmt.Printf("%-22s FAILED: got %x, expected %x\n",
+				test.name, result, expected)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d self-tests failed", failed, len(selfTests))
+	}
+	return nil
+}
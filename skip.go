@@ -0,0 +1,124 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"time"
+)
+
+// skipExisting skips the inputs whose outputs are up to date, set
+// with the -skip-existing flag.
+var skipExisting bool
+
+// upToDate tests if the outputs of all filters exist for the input
+// file f and they are newer than the input. The outputs include the
+// side outputs of the analysis flags, such as -highlight-dups. File f
+// is left positioned at its start.
+func upToDate(f *os.File, path string, filters []filterSpec) (bool, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	cfg, format, err := image.DecodeConfig(f)
+	if err != nil {
+		return false, decodeError(err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	// The GIF outputs are PNGs for single-frame and GIFs for animated
+	// inputs. The animated GIF and 16-bit grayscale TIFF inputs have no
+	// side outputs.
+	var candidates [][]string
+	switch {
+	case format == "tiff" && cfg.ColorModel == color.Gray16Model:
+		candidates = append(candidates, filterOutputs(path, filters, "tiff"))
+	case format == "gif":
+		candidates = append(candidates, filterOutputs(path, filters, "gif"))
+		fallthrough
+	default:
+		candidates = append(candidates,
+			append(filterOutputs(path, filters, "png"),
+				sideOutputs(path, filters)...))
+	}
+	for _, names := range candidates {
+		if newerThan(names, info.ModTime()) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// newerThan tests if all of the named files exist and they are newer
+// than t.
+func newerThan(names []string, t time.Time) bool {
+	for _, name := range names {
+		info, err := os.Stat(name)
+		if err != nil || !info.ModTime().After(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterOutputs returns the names of the filters' output images with
+// the extension ext.
+func filterOutputs(path string, filters []filterSpec, ext string) []string {
+	var names []string
+	for _, filter := range filters {
+		names = append(names, outputName(path, filter.name, ext))
+	}
+	return names
+}
+
+// sideOutputs returns the names of the files that the analysis flags
+// write in addition to the filters' output images.
+func sideOutputs(path string, filters []filterSpec) []string {
+	var names []string
+	add := func(name, ext string) {
+		names = append(names, outputName(path, name, ext))
+	}
+	if debugCBC {
+		add("AES-CBC-chain", "png")
+	}
+	if cbcPadding {
+		add("AES-CBC-Padded", "png")
+		add("AES-CBC-Padded-structure", "png")
+	}
+	if bitplanes {
+		add("AES-ECB-Bitplanes", "png")
+	}
+	if emitPlaintext {
+		add("plaintext", "bin")
+	}
+	if keystreamOut {
+		add("AES-CTR-keystream", "bin")
+	}
+	for _, filter := range filters {
+		if wrongKey && filter.invertible() {
+			add(filter.name+"-wrongkey", "png")
+		}
+		if jpegQuality > 0 {
+			add(filter.name+"-jpeg", "png")
+			if filter.invertible() {
+				add(filter.name+"-jpeg-decrypted", "png")
+			}
+		}
+		if avalancheMap {
+			add(filter.name+"-avalanche", "png")
+		}
+		if highlightDups {
+			add(filter.name+"-dups", "png")
+		}
+	}
+	return names
+}
@@ -0,0 +1,81 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUpToDate(t *testing.T) {
+	defer func() {
+		highlightDups = false
+	}()
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.png")
+	if err := save(image.NewNRGBA(image.Rect(0, 0, 8, 8)), input); err != nil {
+		t.Fatal(err)
+	}
+	selected := []filterSpec{filters[0], filters[3]}
+	now := time.Now()
+	if err := os.Chtimes(input, now, now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(expected bool, msg string) {
+		t.Helper()
+		f, err := os.Open(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		ok, err := upToDate(f, input, selected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != expected {
+			t.Errorf("%s: got %v, expected %v", msg, ok, expected)
+		}
+	}
+	touch := func(name string, mtime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(name, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check(false, "no outputs")
+
+	outputs := filterOutputs(input, selected, "png")
+	touch(outputs[0], now)
+	check(false, "one output missing")
+
+	touch(outputs[1], now.Add(-2*time.Hour))
+	check(false, "stale output")
+
+	touch(outputs[1], now)
+	check(true, "fresh outputs")
+
+	highlightDups = true
+	check(false, "-highlight-dups outputs missing")
+	for _, name := range sideOutputs(input, selected) {
+		touch(name, now)
+	}
+	check(true, "fresh -highlight-dups outputs")
+
+	if err := os.Chtimes(input, now, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	check(false, "modified input")
+}
@@ -0,0 +1,179 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"os"
+)
+
+// streamImage applies the filter to the image m and writes the result
+// into the PNG file name. The output is encoded row by row so only
+// one row of the output is kept in memory.
+func streamImage(m image.Image, filter filterSpec, name string) error {
+	out, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	bounds := m.Bounds()
+	enc, err := newPNGStream(out, bounds.Dx(), bounds.Dy(),
+		zlibLevel(filter.pngLevel()))
+	if err != nil {
+		return err
+	}
+	if err := filterImage(m, filter, enc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// zlibLevel returns the zlib compression level of the PNG compression
+// level.
+func zlibLevel(level png.CompressionLevel) int {
+	switch level {
+	case png.NoCompression:
+		return zlib.NoCompression
+	case png.BestSpeed:
+		return zlib.BestSpeed
+	case png.BestCompression:
+		return zlib.BestCompression
+	default:
+		return zlib.DefaultCompression
+	}
+}
+
+// pngStream encodes an 8-bit RGBA PNG image row by row. It implements
+// the rowSink interface.
+type pngStream struct {
+	w    *bufio.Writer
+	z    *zlib.Writer
+	idat *chunkWriter
+	row  *image.NRGBA
+}
+
+func newPNGStream(w io.Writer, width, height, level int) (*pngStream,
+	error) {
+
+	bw := bufio.NewWriter(w)
+
+	_, err := bw.WriteString("\x89PNG\r\n\x1a\n")
+	if err != nil {
+		return nil, err
+	}
+
+	var ihdr [13]byte
+	binary.BigEndian.PutUint32(ihdr[0:4], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[4:8], uint32(height))
+	ihdr[8] = 8  // Bit depth.
+	ihdr[9] = 6  // Color type: truecolor with alpha.
+	ihdr[10] = 0 // Compression method.
+	ihdr[11] = 0 // Filter method.
+	ihdr[12] = 0 // No interlace.
+	if err := writeChunk(bw, "IHDR", ihdr[:]); err != nil {
+		return nil, err
+	}
+
+	idat := &chunkWriter{
+		w: bw,
+	}
+	z, err := zlib.NewWriterLevel(idat, level)
+	if err != nil {
+		return nil, err
+	}
+	return &pngStream{
+		w:    bw,
+		z:    z,
+		idat: idat,
+		row:  image.NewNRGBA(image.Rect(0, 0, width, 1)),
+	}, nil
+}
+
+func (enc *pngStream) Row(y int) *image.NRGBA {
+	enc.row.Rect.Min.Y = y
+	enc.row.Rect.Max.Y = y + 1
+	return enc.row
+}
+
+func (enc *pngStream) Flush(y int) error {
+	// Each row starts with the filter type byte, 0 for None.
+	if _, err := enc.z.Write([]byte{0}); err != nil {
+		return err
+	}
+	_, err := enc.z.Write(enc.row.Pix)
+	return err
+}
+
+// Close completes the PNG image.
+func (enc *pngStream) Close() error {
+	if err := enc.z.Close(); err != nil {
+		return err
+	}
+	if err := enc.idat.flush(); err != nil {
+		return err
+	}
+	if err := writeChunk(enc.w, "IEND", nil); err != nil {
+		return err
+	}
+	return enc.w.Flush()
+}
+
+// chunkWriter collects the compressed image data into IDAT chunks.
+type chunkWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+const maxChunkSize = 64 * 1024
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= maxChunkSize {
+		if err := cw.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (cw *chunkWriter) flush() error {
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	err := writeChunk(cw.w, "IDAT", cw.buf)
+	cw.buf = cw.buf[:0]
+	return err
+}
+
+func writeChunk(w io.Writer, name string, data []byte) error {
+	var hdr [8]byte
+
+	binary.BigEndian.PutUint32(hdr[0:4], uint32(len(data)))
+	copy(hdr[4:8], name)
+
+	crc := crc32.NewIEEE()
+	crc.Write(hdr[4:8])
+	crc.Write(data)
+
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	var tail [4]byte
+	binary.BigEndian.PutUint32(tail[:], crc.Sum32())
+	_, err := w.Write(tail[:])
+	return err
+}
@@ -0,0 +1,160 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/metrics"
+	"testing"
+	"time"
+)
+
+func TestStreamImage(t *testing.T) {
+	m := genImage(penguin, 37, 20, 8)
+	filter, err := lookupFilter("AES-CTR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(t.TempDir(), "stream.png")
+	if err := streamImage(m, filter, name); err != nil {
+		t.Fatal(err)
+	}
+	streamed, err := loadNRGBA(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := processImage(m, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if streamed.Rect != output.Rect || !bytes.Equal(streamed.Pix, output.Pix) {
+		t.Errorf("streamed output differs from the in-memory output")
+	}
+}
+
+// heapBytes returns the bytes of the live and not yet collected heap
+// objects.
+func heapBytes() uint64 {
+	sample := []metrics.Sample{
+		{Name: "/memory/classes/heap/objects:bytes"},
+	}
+	metrics.Read(sample)
+	return sample[0].Value.Uint64()
+}
+
+// benchmarkOutput benchmarks writing the AES-CTR output of a
+// 2048×2048 image. The peak-MB metric is the peak heap growth during
+// the writes, sampled every millisecond. It includes the garbage of
+// the pixel conversions that the GC has not collected yet.
+func benchmarkOutput(b *testing.B,
+	write func(m image.Image, filter filterSpec, name string) error) {
+
+	m := genImage(penguin, 2048, 2048, 64)
+	filter, err := lookupFilter("AES-CTR")
+	if err != nil {
+		b.Fatal(err)
+	}
+	name := filepath.Join(b.TempDir(), "output.png")
+
+	runtime.GC()
+	base := heapBytes()
+	var peak uint64
+	done := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			if v := heapBytes(); v > peak {
+				peak = v
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := write(m, filter, name); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	close(done)
+	<-sampled
+
+	if peak > base {
+		b.ReportMetric(float64(peak-base)/(1<<20), "peak-MB")
+	}
+}
+
+func BenchmarkOutputStream(b *testing.B) {
+	benchmarkOutput(b, streamImage)
+}
+
+func BenchmarkOutputMemory(b *testing.B) {
+	benchmarkOutput(b, func(m image.Image, filter filterSpec,
+		name string) error {
+
+		output, err := processImage(m, filter)
+		if err != nil {
+			return err
+		}
+		return saveLevel(output, name, filter.pngLevel())
+	})
+}
+
+// benchmarkPNGLevel benchmarks saving a 2048×2048 AES-ECB output with
+// the PNG compression level. The output-bytes metric is the size of
+// the PNG file.
+func benchmarkPNGLevel(b *testing.B, level png.CompressionLevel) {
+	filter, err := lookupFilter("AES-ECB")
+	if err != nil {
+		b.Fatal(err)
+	}
+	output, err := processImage(genImage(penguin, 2048, 2048, 64), filter)
+	if err != nil {
+		b.Fatal(err)
+	}
+	name := filepath.Join(b.TempDir(), "output.png")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := saveLevel(output, name, level); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	info, err := os.Stat(name)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(float64(info.Size()), "output-bytes")
+}
+
+func BenchmarkPNGLevelDefault(b *testing.B) {
+	benchmarkPNGLevel(b, png.DefaultCompression)
+}
+
+func BenchmarkPNGLevelSpeed(b *testing.B) {
+	benchmarkPNGLevel(b, png.BestSpeed)
+}
+
+func BenchmarkPNGLevelBest(b *testing.B) {
+	benchmarkPNGLevel(b, png.BestCompression)
+}
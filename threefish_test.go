@@ -0,0 +1,64 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestThreefishRoundTrip(t *testing.T) {
+	filter, err := lookupFilter("Threefish-512-ECB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.size() != 64 {
+		t.Fatalf("block size %d, expected 64", filter.size())
+	}
+
+	block := testBlock(64)
+	ct := append([]byte(nil), block...)
+	if err := Threefish512(ct, 0); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(ct, block) {
+		t.Errorf("ciphertext equals plaintext")
+	}
+	// A change in the last byte changes the first bytes of the block.
+	flipped := append([]byte(nil), block...)
+	flipped[63] ^= 0x01
+	if err := Threefish512(flipped, 0); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(flipped[:16], ct[:16]) {
+		t.Errorf("changed byte 63 did not change the first 16 bytes")
+	}
+	if err := Threefish512Decrypt(ct, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ct, block) {
+		t.Errorf("decrypted %x, expected %x", ct, block)
+	}
+
+	// Each 64-byte block covers 16 pixels of the 32×4 image.
+	m := image.NewNRGBA(image.Rect(0, 0, 32, 4))
+	for i := range m.Pix {
+		m.Pix[i] = byte(i * 3)
+	}
+	output, err := processImage(m, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := processImage(output, filter.decryptor())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted.Pix, m.Pix) {
+		t.Errorf("decrypted image differs from the input")
+	}
+}
@@ -0,0 +1,40 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"crypto/subtle"
+	"image"
+)
+
+// equalBlocks tests if the blocks a and b are equal. The comparison
+// takes a time independent of the block contents.
+func equalBlocks(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
+
+// verifyDecrypt decrypts the filter's output image ct and compares
+// its blocks with the original image. It returns the number of
+// matching blocks and the total number of blocks.
+func verifyDecrypt(original, ct *image.NRGBA, filter filterSpec) (
+	matched, total int, err error) {
+
+	decrypted, err := processImage(ct, filter.decryptor())
+	if err != nil {
+		return 0, 0, err
+	}
+	err = forEachBlock(original.Rect.Dx(), original.Rect.Dy(),
+		filter.size()/4, func(pos BlockPos) error {
+			if equalBlocks(blockPixels(original, pos),
+				blockPixels(decrypted, pos)) {
+				matched++
+			}
+			total++
+			return nil
+		})
+	return matched, total, err
+}
@@ -0,0 +1,42 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"testing"
+)
+
+func TestEqualBlocks(t *testing.T) {
+	a := testBlock(16)
+	tests := []struct {
+		b        []byte
+		expected bool
+	}{
+		{testBlock(16), true},
+		{append([]byte(nil), a...), true},
+		{testBlock(15), false},
+		{testBlock(17)[1:], false},
+		{nil, false},
+	}
+	for i := 0; i < len(a); i++ {
+		b := testBlock(16)
+		b[i] ^= 0x01
+		tests = append(tests, struct {
+			b        []byte
+			expected bool
+		}{b, false})
+	}
+	for idx, test := range tests {
+		if equalBlocks(a, test.b) != test.expected {
+			t.Errorf("test %d: equalBlocks(%x, %x) != %v", idx, a, test.b,
+				test.expected)
+		}
+	}
+	if !equalBlocks(nil, []byte{}) {
+		t.Errorf("empty blocks are not equal")
+	}
+}
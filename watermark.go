@@ -0,0 +1,69 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// watermarkCorner is the -watermark corner of the output images. An
+// empty value disables the watermarks.
+var watermarkCorner string
+
+var watermarkCorners = []string{
+	"top-left", "top-right", "bottom-left", "bottom-right",
+}
+
+// watermarkText returns the watermark text of the filter's outputs.
+func watermarkText(filter string) string {
+	fp := keyFingerprint
+	if len(fp) == 0 {
+		fp = "default"
+	}
+	return fmt.Sprintf("%s key %s %s", filter, fp,
+		time.Now().Format("2006-01-02"))
+}
+
+// watermark draws the text on a dark box in the -watermark corner of
+// the image m.
+func watermark(m *image.NRGBA, text string) {
+	const margin = 2
+
+	face := basicfont.Face7x13
+	d := &font.Drawer{
+		Dst:  m,
+		Src:  image.White,
+		Face: face,
+	}
+	width := d.MeasureString(text).Ceil() + 2*margin
+	height := face.Height + 2*margin
+
+	x, y := m.Rect.Min.X, m.Rect.Min.Y
+	switch watermarkCorner {
+	case "top-right":
+		x = m.Rect.Max.X - width
+	case "bottom-left":
+		y = m.Rect.Max.Y - height
+	case "bottom-right":
+		x = m.Rect.Max.X - width
+		y = m.Rect.Max.Y - height
+	}
+	box := image.Rect(x, y, x+width, y+height)
+	draw.Draw(m, box, image.NewUniform(color.NRGBA{A: 0xc0}), image.Point{},
+		draw.Over)
+
+	d.Dot = fixed.P(x+margin, y+margin+face.Ascent)
+	d.DrawString(text)
+}
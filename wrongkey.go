@@ -0,0 +1,31 @@
+//
+// Copyright (c) 2022 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"image"
+)
+
+// wrongKeyDecrypt decrypts the ciphertext image with a key that
+// differs from the current key only in its least significant bit.
+func wrongKeyDecrypt(ct *image.NRGBA, filter filterSpec) (*image.NRGBA,
+	error) {
+
+	key := currentKey
+	wrong := append([]byte(nil), key...)
+	wrong[len(wrong)-1] ^= 0x01
+
+	if err := setupKey(wrong); err != nil {
+		return nil, err
+	}
+	result, err := processImage(ct, filter.decryptor())
+
+	if err := setupKey(key); err != nil {
+		return nil, err
+	}
+	return result, err
+}